@@ -0,0 +1,153 @@
+package main
+
+import "math"
+
+// retryQuota is the retry token bucket of the AWS SDKs: retries cost tokens, depending on
+// the error of the failed attempt, and successes refill the bucket, by a fraction of a
+// retry for the calls that succeed at the first attempt
+type retryQuota struct {
+	capacity, tokens float64
+	// cost of a retry, by class of error of the failed attempt
+	timeoutCost, throttleCost, serverErrorCost float64
+	// refill of a call that succeeds at the first attempt; a successful retry refunds its
+	// cost instead
+	noRetryRefill float64
+}
+
+func newRetryQuota() *retryQuota {
+	return &retryQuota{
+		capacity:        500,
+		tokens:          500,
+		timeoutCost:     10,
+		throttleCost:    5,
+		serverErrorCost: 5,
+		noRetryRefill:   1,
+	}
+}
+
+// cost returns the cost of retrying after a failure with err
+func (t *retryQuota) cost(class errorClass) float64 {
+	switch class {
+	case timedOut:
+		return t.timeoutCost
+	case throttled:
+		return t.throttleCost
+	default:
+		return t.serverErrorCost
+	}
+}
+
+// acquire takes amount tokens, if there are enough
+func (t *retryQuota) acquire(amount float64) bool {
+	if t.tokens < amount {
+		return false
+	}
+	t.tokens -= amount
+	return true
+}
+
+func (t *retryQuota) refill(amount float64) {
+	t.tokens = math.Min(t.capacity, t.tokens+amount)
+}
+
+// constants of the client rate limiter of the AWS SDKs
+const (
+	// cubicBeta is the multiplicative decrease of the sending rate after a throttle
+	cubicBeta = 0.7
+	// cubicScale scales the cubic growth of the sending rate after a throttle
+	cubicScale = 0.4
+	// minFillRate and minCapacity bound the token bucket of the limiter from below
+	minFillRate = 0.5
+	minCapacity = 1
+	// smoothing of the measured sending rate
+	smoothing = 0.8
+	// measurementBucket is the duration of the buckets of the measured sending rate
+	measurementBucket = 0.5
+)
+
+// rateLimiter is the client side rate limiter of the adaptive retry mode of the AWS
+// SDKs. It is disabled until the first throttle, then limits the rate of the attempts
+// with a token bucket whose rate follows CUBIC: it drops to cubicBeta of the measured
+// rate on each throttle, and grows back as a cubic function of the time since then.
+type rateLimiter struct {
+	// now returns the current simulation time
+	now func() float64
+
+	enabled                bool
+	fillRate, maxCapacity  float64
+	capacity               float64
+	lastRefill             float64
+	lastMaxRate            float64
+	lastThrottle           float64
+	timeWindow             float64
+	measuredRate           float64
+	lastMeasurement        float64
+	attemptsSinceMeasuring int
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{fillRate: minFillRate, maxCapacity: minCapacity}
+}
+
+// acquire takes a token for an attempt, and returns how long to wait before sending it
+func (t *rateLimiter) acquire() float64 {
+	if !t.enabled {
+		return 0
+	}
+	t.refill()
+	var delay float64
+	if t.capacity < 1 {
+		delay = (1 - t.capacity) / t.fillRate
+	}
+	// the token is taken now, leaving the bucket in debt until the attempt is sent
+	t.capacity--
+	return delay
+}
+
+func (t *rateLimiter) refill() {
+	now := t.now()
+	t.capacity = math.Min(t.maxCapacity, t.capacity+(now-t.lastRefill)*t.fillRate)
+	t.lastRefill = now
+}
+
+// update adapts the sending rate to the response to an attempt
+func (t *rateLimiter) update(throttled bool) {
+	t.measure()
+	var rate float64
+	if throttled {
+		rate = t.measuredRate
+		if t.enabled {
+			rate = math.Min(rate, t.fillRate)
+		}
+		t.lastMaxRate = rate
+		t.updateTimeWindow()
+		t.lastThrottle = t.now()
+		rate *= cubicBeta
+		t.enabled = true
+	} else {
+		t.updateTimeWindow()
+		rate = cubicScale*math.Pow(t.now()-t.lastThrottle-t.timeWindow, 3) + t.lastMaxRate
+	}
+
+	rate = math.Min(rate, 2*t.measuredRate)
+	t.refill()
+	t.fillRate = math.Max(rate, minFillRate)
+	t.maxCapacity = math.Max(rate, minCapacity)
+	t.capacity = math.Min(t.capacity, t.maxCapacity)
+}
+
+// measure updates the measured rate of the responses, in buckets of measurementBucket
+func (t *rateLimiter) measure() {
+	bucket := math.Floor(t.now()/measurementBucket) * measurementBucket
+	t.attemptsSinceMeasuring++
+	if bucket > t.lastMeasurement {
+		rate := float64(t.attemptsSinceMeasuring) / (bucket - t.lastMeasurement)
+		t.measuredRate = rate*smoothing + t.measuredRate*(1-smoothing)
+		t.attemptsSinceMeasuring = 0
+		t.lastMeasurement = bucket
+	}
+}
+
+func (t *rateLimiter) updateTimeWindow() {
+	t.timeWindow = math.Cbrt(t.lastMaxRate * (1 - cubicBeta) / cubicScale)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"napicella.com/simulators/eventloop/validation"
+)
+
+// backpressureSignal is the signal the server computes the retry-after hint of its
+// failures from, to ask the clients to back off while it is overloaded
+type backpressureSignal int
+
+const (
+	// noBackpressure attaches only the fixed retry-after hint of the server to the
+	// throttled responses
+	noBackpressure backpressureSignal = iota
+	// queueBackpressure hints to retry after the estimated wait in the queue, that is
+	// once the queue drained
+	queueBackpressure
+	// utilizationBackpressure hints to retry after the wait of an M/M/c queue at the
+	// estimated utilization of the server, once it exceeds utilizationThreshold
+	utilizationBackpressure
+)
+
+func (d backpressureSignal) String() string {
+	return [...]string{"none", "queue", "utilization"}[d]
+}
+
+func parseBackpressure(name string) (backpressureSignal, error) {
+	for b := noBackpressure; b <= utilizationBackpressure; b++ {
+		if b.String() == name {
+			return b, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid backpressure %q", name)
+}
+
+const (
+	// utilizationThreshold is the utilization above which the server hints
+	utilizationThreshold = 0.8
+	// maxRetryAfter caps the hints of the server
+	maxRetryAfter = 10.0
+)
+
+// retryAfterHint returns how long the server asks the client to wait before retrying a
+// request failed with class, zero for no hint. The client errors have no hint: retrying
+// them fails again.
+func (t *server) retryAfterHint(class errorClass) float64 {
+	if class == clientError {
+		return 0
+	}
+	var hint float64
+	switch t.backpressure {
+	case queueBackpressure:
+		hint = t.estimatedWait()
+	case utilizationBackpressure:
+		if u := t.utilization(); u >= 1 {
+			hint = maxRetryAfter
+		} else if u > utilizationThreshold {
+			hint = validation.MMc(1/t.meanInterarrival, 1/t.serviceEstimate(),
+				max(t.workers, 1)).Wait
+		}
+	}
+	if class == throttled {
+		hint = math.Max(hint, t.retryAfter)
+	}
+	return math.Min(hint, maxRetryAfter)
+}
+
+// utilization is the estimated utilization of the server: the arrival rate, retries
+// included, times the service time, over the number of workers
+func (t *server) utilization() float64 {
+	if t.meanInterarrival == 0 {
+		return 0
+	}
+	return t.serviceEstimate() / (t.meanInterarrival * float64(max(t.workers, 1)))
+}
+
+// observeArrival updates the moving average of the time between the requests, with a
+// request arrived at t_
+func (t *server) observeArrival(t_ float64) {
+	// the weight of the last interarrival time in the moving average
+	const alpha = 0.1
+	switch t.arrivals {
+	case 0:
+	case 1:
+		t.meanInterarrival = t_ - t.lastArrival
+	default:
+		t.meanInterarrival = alpha*(t_-t.lastArrival) + (1-alpha)*t.meanInterarrival
+	}
+	t.arrivals++
+	t.lastArrival = t_
+}
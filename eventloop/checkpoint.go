@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+func init() {
+	// the payloads of the events, saved in the snapshots of the simulations
+	gob.Register(attemptRef{})
+	gob.Register(response{})
+	gob.Register(job{})
+}
+
+// checkpoint saves the state of the parts of the model, or loads it, one field at the
+// time: unlike the gob encoding of whole values, it works with unexported fields. The
+// same checkpoint method of a part both saves and loads it, so that the fields are loaded
+// in the order they were saved.
+type checkpoint struct {
+	enc *gob.Encoder
+	dec *gob.Decoder
+	err error
+}
+
+// checkpointer is implemented by the parts of the model whose state is saved
+type checkpointer interface {
+	checkpoint(c *checkpoint)
+}
+
+// save returns the state of cp
+func save(cp checkpointer) ([]byte, error) {
+	var b bytes.Buffer
+	c := &checkpoint{enc: gob.NewEncoder(&b)}
+	cp.checkpoint(c)
+	return b.Bytes(), c.err
+}
+
+// load loads in cp the state returned by save
+func load(cp checkpointer, b []byte) error {
+	c := &checkpoint{dec: gob.NewDecoder(bytes.NewReader(b))}
+	cp.checkpoint(c)
+	return c.err
+}
+
+func (t *checkpoint) loading() bool {
+	return t.dec != nil
+}
+
+// fields saves or loads the values pointed to by ps. The values are zeroed before being
+// loaded, so that the slices and maps loaded never share memory with the ones replaced.
+func (t *checkpoint) fields(ps ...interface{}) {
+	for _, p := range ps {
+		if t.err != nil {
+			return
+		}
+		if !t.loading() {
+			t.err = t.enc.Encode(p)
+			continue
+		}
+		v := reflect.ValueOf(p).Elem()
+		v.Set(reflect.Zero(v.Type()))
+		t.err = t.dec.Decode(p)
+	}
+}
+
+// size saves the length n of a slice, or loads it, and returns it
+func (t *checkpoint) size(n int) int {
+	t.fields(&n)
+	return n
+}
+
+// retrier saves or loads the state of r
+func (t *checkpoint) retrier(r retrier) {
+	if cp, ok := r.(checkpointer); ok {
+		cp.checkpoint(t)
+	} else if t.err == nil {
+		t.err = fmt.Errorf("the state of the retrier %T cannot be saved", r)
+	}
+}
+
+// MarshalBinary saves the state of the client: its calls in flight and the state shared
+// by its retriers. The stats, shared with the server, are saved with the server.
+func (t *client) MarshalBinary() ([]byte, error) {
+	return save(t)
+}
+
+// UnmarshalBinary loads the state saved by MarshalBinary, in a client with the same
+// configuration
+func (t *client) UnmarshalBinary(b []byte) error {
+	return load(t, b)
+}
+
+func (t *client) checkpoint(c *checkpoint) {
+	c.fields(&t.drain, &t.lastCall, &t.retriersSince)
+	t.retrierFactory.checkpoint(c)
+
+	var calls []*call
+	if !c.loading() {
+		// by id, ranging over the map would not be deterministic
+		for _, cl := range t.calls {
+			calls = append(calls, cl)
+		}
+		sort.Slice(calls, func(i, j int) bool { return calls[i].id < calls[j].id })
+		if len(calls) > 0 && calls[0].id < t.retriersSince && c.err == nil {
+			// their retriers would be loaded from the factory of the other strategy
+			c.err = fmt.Errorf("call %d started before the retriers changed", calls[0].id)
+		}
+	}
+	n := c.size(len(calls))
+	if c.loading() {
+		t.calls = make(map[int]*call, n)
+		for i := 0; i < n; i++ {
+			calls = append(calls, &call{client: t, r: t.retrierFactory.get(), stats: t.stats})
+		}
+	}
+	for _, cl := range calls {
+		cl.checkpoint(c)
+		if c.loading() {
+			t.calls[cl.id] = cl
+		}
+	}
+}
+
+func (t *call) checkpoint(c *checkpoint) {
+	c.fields(&t.id, &t.currentAttempt, &t.started, &t.firstAwaited, &t.failed,
+		&t.attemptTimeout, &t.deadline, &t.done)
+	c.retrier(t.r)
+}
+
+// MarshalBinary saves the state of the server, its queue and the requests in progress,
+// and the stats of the simulation
+func (t *server) MarshalBinary() ([]byte, error) {
+	return save(t)
+}
+
+// UnmarshalBinary loads the state saved by MarshalBinary, in a server with the same
+// configuration
+func (t *server) UnmarshalBinary(b []byte) error {
+	return load(t, b)
+}
+
+func (t *server) checkpoint(c *checkpoint) {
+	n := c.size(len(t.requests))
+	if c.loading() {
+		t.requests = make([]request, n)
+	}
+	for i := range t.requests {
+		t.requests[i].checkpoint(c)
+	}
+
+	n = c.size(len(t.serving))
+	if c.loading() {
+		t.serving = make([]*serving, n)
+	}
+	for i := range t.serving {
+		busy := t.serving[i] != nil
+		c.fields(&busy)
+		if !busy {
+			continue
+		}
+		if c.loading() {
+			t.serving[i] = &serving{}
+		}
+		s := t.serving[i]
+		s.req.checkpoint(c)
+		c.fields(&s.failed, &s.class, &s.worker, &s.started, &s.job, &s.interrupted,
+			&s.interruption)
+	}
+
+	c.fields(&t.jobs, &t.meanService, &t.lastEmpty, &t.lastQueueChange,
+		&t.meanInterarrival, &t.lastArrival, &t.arrivals,
+		&t.sampledSuccesses, &t.sampledAttempts)
+	t.stats.checkpoint(c)
+}
+
+func (t *request) checkpoint(c *checkpoint) {
+	c.fields(&t.time, &t.call, &t.attempt)
+}
+
+// checkpoint saves or loads the stats gathered so far. The breaker is the one of the
+// client, and the stats of the components are only collected at the end.
+func (t *stats) checkpoint(c *checkpoint) {
+	c.fields(&t.uniqueCalls, &t.attempts, &t.reqLatencies, &t.callLatencies,
+		&t.reqSuccessCount, &t.reqFailedCount, &t.crashCount, &t.interruptedCount,
+		&t.rejectedCount, &t.refusedCount, &t.hintedCount, &t.hedgedCount,
+		&t.cancelledByClientCount, &t.classFailures, &t.shortCircuitedCount,
+		&t.timedOutCount, &t.cancelledCount, &t.wastedWork, &t.served, &t.waitingTime,
+		&t.busyTime, &t.workerBusyTime, &t.queueArea)
+
+	n := c.size(len(t.samples))
+	if c.loading() {
+		t.samples = make([]loadSample, n)
+	}
+	for i := range t.samples {
+		s := &t.samples[i]
+		c.fields(&s.time, &s.queued, &s.goodput, &s.throughput)
+	}
+}
+
+// The retriers save the state of the call they retry. The state they share with the
+// other calls, like a circuit breaker, is saved by their factory.
+
+func (t *fixedRetrier) checkpoint(c *checkpoint) {
+	c.fields(&t.currentAttempt)
+}
+
+func (t *backoffRetrier) checkpoint(c *checkpoint) {
+	t.fixedRetrier.checkpoint(c)
+	c.fields(&t.sleep)
+}
+
+func (t *circuitBreakerRetrier) checkpoint(c *checkpoint) {
+	c.retrier(t.r)
+	c.fields(&t.probe, &t.halfOpens)
+}
+
+// checkpoint does nothing: the token bucket is shared by the calls
+func (t *tokenBucketRetrier) checkpoint(c *checkpoint) {}
+
+func (t *adaptiveRetrier) checkpoint(c *checkpoint) {
+	t.backoffRetrier.checkpoint(c)
+	c.fields(&t.lastClass, &t.retryCost)
+}
+
+func (t *hedgingRetrier) checkpoint(c *checkpoint) {
+	t.fixedRetrier.checkpoint(c)
+	c.fields(&t.hedges)
+}
+
+func (t *retryAfterRetrier) checkpoint(c *checkpoint) {
+	t.backoffRetrier.checkpoint(c)
+	c.fields(&t.hint)
+}
+
+// The factories save the state shared by their retriers.
+
+func (t *fixedRetrierFactory) checkpoint(c *checkpoint)      {}
+func (t *backoffRetrierFactory) checkpoint(c *checkpoint)    {}
+func (t *retryAfterRetrierFactory) checkpoint(c *checkpoint) {}
+
+func (t *circuitBreakerRetrierFactory) checkpoint(c *checkpoint) {
+	t.breaker.checkpoint(c)
+}
+
+func (t *retryBudgetFactory) checkpoint(c *checkpoint) {
+	c.fields(&t.budget.calls, &t.budget.retries)
+}
+
+func (t *adaptiveRetrierFactory) checkpoint(c *checkpoint) {
+	l := t.limiter
+	c.fields(&t.quota.tokens, &l.enabled, &l.fillRate, &l.maxCapacity, &l.capacity,
+		&l.lastRefill, &l.lastMaxRate, &l.lastThrottle, &l.timeWindow, &l.measuredRate,
+		&l.lastMeasurement, &l.attemptsSinceMeasuring)
+}
+
+func (t *hedgingRetrierFactory) checkpoint(c *checkpoint) {
+	p := t.policy
+	c.fields(&p.latencies, &p.next, &p.percentileDelay)
+}
+
+func (t *tokenBucketFactory) checkpoint(c *checkpoint) {
+	t.get()
+	c.fields(&t.r.numberOfTokens)
+}
+
+// checkpoint saves the token bucket and the fixed retrier, both shared by the calls
+func (t *tokenBucketFixedRetrierFactory) checkpoint(c *checkpoint) {
+	t.get()
+	c.fields(&t.r.numberOfTokens)
+	c.retrier(t.r.fixedRetrier)
+}
+
+func (t *breaker) checkpoint(c *checkpoint) {
+	c.fields(&t.state, &t.openedAt, &t.probesSent, &t.probesSucceeded, &t.halfOpens)
+
+	n := c.size(len(t.window))
+	if c.loading() {
+		t.window = make([]outcome, n)
+	}
+	for i := range t.window {
+		c.fields(&t.window[i].time, &t.window[i].failed)
+	}
+	n = c.size(len(t.timeline))
+	if c.loading() {
+		t.timeline = make([]breakerTransition, n)
+	}
+	for i := range t.timeline {
+		c.fields(&t.timeline[i].time, &t.timeline[i].state)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import "errors"
+
+// errCircuitOpen is the reason of the failure of the calls short-circuited by an open
+// circuit breaker, which are never sent to the server
+var errCircuitOpen = errors.New("circuit open")
+
+// breakerState is the state of a circuit breaker
+type breakerState int
+
+const (
+	// closed lets all the attempts through, tracking their outcome
+	closed breakerState = iota
+	// halfOpen lets a few probe attempts through, to find out if the server recovered
+	halfOpen
+	// open rejects the attempts until the open duration elapses
+	open
+)
+
+func (d breakerState) String() string {
+	return [...]string{"closed", "half-open", "open"}[d]
+}
+
+// breakerTransition is a change of state of a circuit breaker
+type breakerTransition struct {
+	time  float64
+	state breakerState
+}
+
+// outcome of an attempt, in the window of a circuit breaker
+type outcome struct {
+	time   float64
+	failed bool
+}
+
+// breaker is the circuit breaker shared by the calls of a client. It opens when the
+// failure rate of the attempts in its window reaches failureThreshold, stays open for
+// openDuration, then lets probes attempts through: it closes if they all succeed, and
+// opens again as soon as one fails.
+type breaker struct {
+	// now returns the current simulation time
+	now func() float64
+
+	// failureThreshold is the failure rate that opens the breaker, computed once the
+	// window has at least minCalls outcomes
+	failureThreshold float64
+	minCalls         int
+	// the window is the last windowSize outcomes if windowSize is positive, the outcomes
+	// in the last windowDuration otherwise
+	windowSize     int
+	windowDuration float64
+	openDuration   float64
+	probes         int
+	// shortCircuit makes the breaker fail the first attempts of the calls too, not only
+	// their retries
+	shortCircuit bool
+
+	state    breakerState
+	window   []outcome
+	openedAt float64
+	// probes sent in half-open state, and how many of them succeeded
+	probesSent, probesSucceeded int
+	// halfOpens counts the transitions to half-open, to tell the probes of a half-open
+	// period from the ones of the previous periods
+	halfOpens int
+	// timeline records the changes of state, starting from closed at 0
+	timeline []breakerTransition
+}
+
+func newBreaker() *breaker {
+	return &breaker{
+		failureThreshold: 0.5,
+		minCalls:         10,
+		windowSize:       20,
+		openDuration:     10,
+		probes:           3,
+		timeline:         []breakerTransition{{time: 0, state: closed}},
+	}
+}
+
+// allow returns true if an attempt can be sent, and if it is a probe
+func (t *breaker) allow() (allowed, probe bool) {
+	if t.state == open && t.now()-t.openedAt >= t.openDuration {
+		t.transition(halfOpen)
+	}
+	switch t.state {
+	case closed:
+		return true, false
+	case halfOpen:
+		if t.probesSent < t.probes {
+			t.probesSent++
+			return true, true
+		}
+	}
+	return false, false
+}
+
+// releaseProbe gives back the probe granted by allow during the half-open period
+// halfOpens, for an attempt that was not sent: its outcome would never be recorded, and
+// the breaker would wait for it forever
+func (t *breaker) releaseProbe(halfOpens int) {
+	if t.state == halfOpen && t.halfOpens == halfOpens && t.probesSent > 0 {
+		t.probesSent--
+	}
+}
+
+// record the outcome of an attempt. In half-open state, only the outcome of the probes
+// counts; in open state, the outcomes of the attempts sent before opening are ignored.
+func (t *breaker) record(probe, failed bool) {
+	switch t.state {
+	case closed:
+		now := t.now()
+		t.window = append(t.window, outcome{time: now, failed: failed})
+		if t.windowSize > 0 && len(t.window) > t.windowSize {
+			t.window = t.window[len(t.window)-t.windowSize:]
+		}
+		for t.windowSize <= 0 && len(t.window) > 0 && now-t.window[0].time > t.windowDuration {
+			t.window = t.window[1:]
+		}
+		if len(t.window) >= t.minCalls && t.failureRate() >= t.failureThreshold {
+			t.transition(open)
+		}
+	case halfOpen:
+		if !probe {
+			return
+		}
+		if failed {
+			t.transition(open)
+			return
+		}
+		t.probesSucceeded++
+		if t.probesSucceeded == t.probes {
+			t.transition(closed)
+		}
+	}
+}
+
+func (t *breaker) failureRate() float64 {
+	failures := 0
+	for _, o := range t.window {
+		if o.failed {
+			failures++
+		}
+	}
+	return float64(failures) / float64(len(t.window))
+}
+
+func (t *breaker) transition(state breakerState) {
+	t.state = state
+	t.window = nil
+	t.probesSent, t.probesSucceeded = 0, 0
+	switch state {
+	case open:
+		t.openedAt = t.now()
+	case halfOpen:
+		t.halfOpens++
+	}
+	t.timeline = append(t.timeline, breakerTransition{time: t.now(), state: state})
+}
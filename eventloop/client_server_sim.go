@@ -5,52 +5,96 @@
 // behaviour of different retry strategies on metrics like server load and request
 // latency. The simulation assumes one client and one server. The client sends requests
 // periodically whether or not the previous request was full filled by the server.
-// The server receives the requests and pushes them to an infinite queue, picking them up
-// one at the time. Each request takes some random processing time, modelled as a random
+// The server receives the requests and pushes them to a queue, unbounded unless limited
+// by a shed policy, picking them up one at the time or, with a pool of workers, a few at
+// the time. Each request takes some random processing time, modelled as a random
 // variable. Some of the requests might fail (based on the server failure rate, modelled
 // with another random variable). The client retries the failed request using different
 // retry strategies.
 // The simulation gathers statistics on server load and request latency for each retry
 // strategy and for each failure rate from 0 (no failures) to 1 (all requests fail).
+// With the -metastable flag, it runs instead a scenario where the processing time grows
+// with the load of the server, and a temporary slowdown leaves the server overloaded by
+// the retries after it is over.
+//
+// The model draws from the random number generator of the simulation, its events refer
+// to the handlers registered by the client and the server, and both implement sim.State:
+// a simulation built by newModel can be saved with sim.Simulation.Snapshot and resumed
+// with Restore, e.g. to try several failure rates from the same warmed-up state. They
+// implement sim.Cloner too, for sim.Simulation.Fork to copy the simulation in memory,
+// e.g. to compare changing the retrier mid-incident, see client.useRetrier, with keeping
+// it.
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	mathstats "github.com/montanaflynn/stats"
+	"io"
 	"math"
-	mathrand "math/rand"
+	"napicella.com/simulators/eventloop/validation"
 	"napicella.com/simulators/simulation"
+	"os"
+	"os/signal"
+	"sort"
 )
 
 type client struct {
+	sim.Base
+	sim                *sim.Simulation
 	stats              *stats
 	server             *server
 	requestsPerSeconds int
 	currentAttempt     int
 
 	retrierFactory retrierFactory
+	// retriersSince is the id of the first call whose retrier comes from retrierFactory,
+	// see useRetrier
+	retriersSince int
+	// attemptTimeout and timeout are how long the client waits for an attempt and for a
+	// call, across its attempts, before giving up on them. Zero means forever.
+	attemptTimeout float64
+	timeout        float64
+	// interarrival is the distribution of the time between two calls, nil for the
+	// default: normally distributed around requestsPerSeconds
+	interarrival distribution
+	// calls are the calls in flight by id, and lastCall the id of the last call
+	calls    map[int]*call
+	lastCall int
 
 	drain bool
 }
 
+// handler returns the name the component c registers the handler of its events of the
+// given kind with. The events refer to their callback by name, for the simulation to be
+// saved in a snapshot.
+func handler(c sim.Component, kind string) string {
+	return sim.Path(c) + "/" + kind
+}
+
 func (t *client) genLoad(time float64, payload interface{}) []sim.Event {
 	if t.drain {
 		return nil
 	}
 
-	desiredStdDev := 0.1
-	desiredMean := float64(t.requestsPerSeconds)
-	nextCall := math.Abs(mathrand.NormFloat64()*desiredStdDev + desiredMean)
+	interarrival := t.interarrival
+	if interarrival == nil {
+		interarrival = normal(float64(t.requestsPerSeconds), 0.1)
+	}
+	nextCall := interarrival(t.sim.Rand())
 
 	return []sim.Event{
 		{
-			Time:        time + nextCall,
-			CallbackFun: t.genLoad,
-			Payload:     nil,
+			Time:    time + nextCall,
+			Handler: handler(t, "gen-load"),
+			Payload: nil,
 		},
 		{
-			Time:        time,
-			CallbackFun: t.call,
-			Payload:     nil,
+			Time:    time,
+			Handler: handler(t, "call"),
+			Payload: nil,
 		},
 	}
 }
@@ -59,205 +103,1096 @@ func (t *client) stopLoadGen() {
 	t.drain = true
 }
 
+// Start starts generating load
+func (t *client) Start(s *sim.Simulation) []sim.Event {
+	t.sim = s
+	t.register(s)
+	if f, ok := t.retrierFactory.(simulatedFactory); ok {
+		f.start(s)
+	}
+	return []sim.Event{
+		{
+			Time:    s.Now(),
+			Handler: handler(t, "gen-load"),
+			Payload: nil,
+		},
+	}
+}
+
+// register registers with s the handlers of the events of the client
+func (t *client) register(s *sim.Simulation) {
+	s.Register(handler(t, "gen-load"), t.genLoad)
+	s.Register(handler(t, "call"), t.call)
+	s.Register(handler(t, "resend"), t.onCall((*call).resend))
+	s.Register(handler(t, "hedge"), t.onCall((*call).hedge))
+	s.Register(handler(t, "attempt-timeout"), t.onCall((*call).attemptTimedOut))
+	s.Register(handler(t, "success"), t.onCall((*call).callSuccess))
+	s.Register(handler(t, "failure"), t.onCall((*call).callFailed))
+}
+
+// callEvent is implemented by the payloads of the events of the calls
+type callEvent interface {
+	// callID returns the id of the call of the event
+	callID() int
+}
+
+// onCall returns the handler of the events of the calls, which calls h with the call of
+// the event. The events of the calls that completed are ignored.
+func (t *client) onCall(
+	h func(c *call, t_ float64, payload interface{}) []sim.Event) sim.Callback {
+
+	return func(t_ float64, payload interface{}) []sim.Event {
+		c, ok := t.calls[payload.(callEvent).callID()]
+		if !ok {
+			return nil
+		}
+		return h(c, t_, payload)
+	}
+}
+
+// add adds c to the calls in flight of the client, with a new id
+func (t *client) add(c *call) {
+	if t.calls == nil {
+		t.calls = make(map[int]*call)
+	}
+	t.lastCall++
+	c.id, c.client = t.lastCall, t
+	t.calls[c.id] = c
+}
+
+// useRetrier makes the calls the client starts from now on use the retriers of the
+// strategy name, e.g. to change strategy in a fork. The calls in flight keep their
+// retriers: the client cannot be saved or cloned again until they completed.
+func (t *client) useRetrier(name retrierFactoryName) {
+	t.retrierFactory = getFactory(name)
+	t.retriersSince = t.lastCall + 1
+	t.stats.breaker = nil
+	if f, ok := t.retrierFactory.(*circuitBreakerRetrierFactory); ok {
+		t.stats.breaker = f.breaker
+	}
+	if f, ok := t.retrierFactory.(simulatedFactory); ok {
+		f.start(t.sim)
+	}
+}
+
+// waitingFor returns true if the client is still waiting for the response to r
+func (t *client) waitingFor(r request) bool {
+	c, ok := t.calls[r.call]
+	return ok && c.waitingFor(r.attempt)
+}
+
+func (t *client) Stats() map[string]float64 {
+	stats := map[string]float64{
+		"calls":            float64(t.stats.uniqueCalls),
+		"attempts":         float64(t.stats.attempts),
+		"succeeded":        float64(t.stats.reqSuccessCount),
+		"failed":           float64(t.stats.reqFailedCount),
+		"timed-out":        float64(t.stats.timedOutCount),
+		"short-circuited":  float64(t.stats.shortCircuitedCount),
+		"hedged":           float64(t.stats.hedgedCount),
+		"hinted":           float64(t.stats.hintedCount),
+		"load":             t.stats.getLoad(),
+		"p90-latency":      t.stats.getp90Latency(),
+		"p90-call-latency": t.stats.getp90CallLatency(),
+	}
+	for c, n := range t.stats.classFailures {
+		stats[errorClass(c).String()+"-failures"] = float64(n)
+	}
+	return stats
+}
+
 func (t *client) call(time float64, payload interface{}) []sim.Event {
 	t.stats.uniqueCalls++
-	t.stats.attempts++
 
 	retrier := t.retrierFactory.get()
 	retrier.initCall()
+	if g, ok := retrier.(callGate); ok && !g.allowCall() {
+		// failed without sending any attempt
+		retrier.recordFailure(errCircuitOpen)
+		t.stats.shortCircuitedCount++
+		t.stats.reqFailedCount++
+		return nil
+	}
+	t.stats.attempts++
 
 	c := &call{
 		r:              retrier,
 		stats:          t.stats,
-		server:         t.server,
 		currentAttempt: 0,
+		attemptTimeout: t.attemptTimeout,
+		started:        time,
+	}
+	t.add(c)
+	if t.timeout > 0 {
+		c.deadline = time + t.timeout
+	}
+	if p, ok := retrier.(callPacer); ok {
+		if delay := p.callDelay(); delay > 0 {
+			return []sim.Event{
+				{
+					Time:    time + delay,
+					Handler: handler(t, "resend"),
+					Payload: attemptRef{Call: c.id},
+				},
+			}
+		}
 	}
 
-	return t.server.sendRequest(time, c)
+	return c.send(time)
 }
 
 type call struct {
-	r              retrier
-	stats          *stats
-	server         *server
+	// id identifies the call in the events, see callEvent, and client is the client
+	// making the call
+	id     int
+	client *client
+	r      retrier
+	stats  *stats
+	// currentAttempt is the number of the last attempt sent
 	currentAttempt int
+	// started is the time of the call, the latency of the call is measured from it
+	started float64
+	// the client waits for the responses to the attempts from firstAwaited to
+	// currentAttempt, except the failed ones: more than one when hedging
+	firstAwaited int
+	failed       map[int]bool
+	// attemptTimeout is how long the client waits for each attempt, forever if zero
+	attemptTimeout float64
+	// deadline is when the client gives up on the call, never if zero
+	deadline float64
+	// done is true once the call succeeded or failed: the responses to its attempts are
+	// not awaited anymore
+	done bool
+}
+
+// attemptRef is the payload of the events of an attempt of a call
+type attemptRef struct {
+	Call, Attempt int
+}
+
+func (t attemptRef) callID() int { return t.Call }
+
+// response is the payload of the response of the server to an attempt sent at Sent. The
+// response is a failure unless Failure is nil.
+type response struct {
+	Call, Attempt int
+	Sent          float64
+	Failure       *failure
+}
+
+func (t response) callID() int { return t.Call }
+
+var (
+	// errAttemptTimeout is the reason of the failure of the attempts the client gave up on
+	errAttemptTimeout = errors.New("attempt timed out")
+	// errDeadlineExceeded is the reason of the failure of the calls the client gave up on
+	errDeadlineExceeded = errors.New("call deadline exceeded")
+)
+
+// send sends an attempt to the server, and starts the timer of the attempt and, when
+// hedging, the timer of its backup
+func (t *call) send(t_ float64) []sim.Event {
+	timeout := math.Inf(1)
+	if t.attemptTimeout > 0 {
+		timeout = t_ + t.attemptTimeout
+	}
+	if t.deadline > 0 {
+		timeout = math.Min(timeout, t.deadline)
+	}
+	if !math.IsInf(timeout, 1) {
+		// the timer is not cancelled when the client stops waiting for the attempt: like
+		// the timer of the backup, it is ignored then
+		t.client.sim.Schedule(sim.Event{
+			Time:    timeout,
+			Handler: handler(t.client, "attempt-timeout"),
+			Payload: attemptRef{Call: t.id, Attempt: t.currentAttempt},
+		})
+	}
+	t.scheduleHedge(t_)
+	return t.client.server.sendRequest(t_, t)
+}
+
+// scheduleHedge schedules the backup of the current attempt, if the retrier hedges
+func (t *call) scheduleHedge(t_ float64) {
+	if h, ok := t.r.(callHedger); ok {
+		if delay := h.nextHedge(); delay > 0 {
+			t.client.sim.Schedule(sim.Event{
+				Time:    t_ + delay,
+				Handler: handler(t.client, "hedge"),
+				Payload: attemptRef{Call: t.id, Attempt: t.firstAwaited},
+			})
+		}
+	}
+}
+
+// hedge sends a backup attempt, unless the call completed or was retried since the
+// hedge was scheduled for the attempts from the one of payload
+func (t *call) hedge(t_ float64, payload interface{}) []sim.Event {
+	if t.done || payload.(attemptRef).Attempt != t.firstAwaited {
+		return nil
+	}
+	t.stats.attempts++
+	t.stats.hedgedCount++
+	t.currentAttempt++
+	t.scheduleHedge(t_)
+	return t.client.server.sendRequest(t_, t)
+}
+
+// waitingFor returns true if the client is still waiting for the response to attempt
+func (t *call) waitingFor(attempt int) bool {
+	return !t.done && attempt >= t.firstAwaited && attempt <= t.currentAttempt &&
+		!t.failed[attempt]
+}
+
+// inFlight returns the number of attempts the client waits for
+func (t *call) inFlight() int {
+	return t.currentAttempt - t.firstAwaited + 1 - len(t.failed)
 }
 
 func (t *call) callSuccess(time float64, payload interface{}) []sim.Event {
+	resp := payload.(response)
+	if !t.waitingFor(resp.Attempt) {
+		return nil
+	}
+	if h, ok := t.r.(callHedger); ok {
+		h.recordLatency(time - resp.Sent)
+		if h.cancelLosers() {
+			for a := t.firstAwaited; a <= t.currentAttempt; a++ {
+				if a != resp.Attempt && !t.failed[a] {
+					t.client.server.cancel(t, a)
+				}
+			}
+		}
+	}
+	t.complete()
 	t.r.recordSuccess()
 	t.stats.reqSuccessCount++
-	req := payload.(*request)
-	t.stats.requestLatency(time - req.time)
+	t.stats.requestLatency(time - resp.Sent)
+	t.stats.callLatency(time - t.started)
 
 	return nil
 }
 
 func (t *call) callFailed(time float64, payload interface{}) []sim.Event {
-	t.r.recordFailure()
+	resp := payload.(response)
+	if !t.waitingFor(resp.Attempt) {
+		return nil
+	}
+	if t.inFlight() > 1 {
+		// wait for the other attempts
+		if t.failed == nil {
+			t.failed = make(map[int]bool)
+		}
+		t.failed[resp.Attempt] = true
+		return nil
+	}
+	return t.retry(time, resp.Failure.err())
+}
+
+// attemptTimedOut gives up on the attempt of payload, and on the call if it is past its
+// deadline
+func (t *call) attemptTimedOut(t_ float64, payload interface{}) []sim.Event {
+	if t.done || payload.(attemptRef).Attempt != t.firstAwaited {
+		return nil
+	}
+	t.stats.timedOutCount++
+	if t.deadline > 0 && t_ >= t.deadline {
+		t.recordFailure(errDeadlineExceeded)
+		t.complete()
+		t.stats.reqFailedCount++
+		return nil
+	}
+	return t.retry(t_, errAttemptTimeout)
+}
 
-	if t.r.shouldRetry() {
+// retry records the failure of the current attempts, with reason err, and retries the
+// call if the retrier allows it
+func (t *call) retry(t_ float64, err error) []sim.Event {
+	if t.recordFailure(err).retryable() && t.r.shouldRetry() {
+		delay := t.r.delay()
+		if t.deadline > 0 && t_+delay >= t.deadline {
+			// the retry would be sent past the deadline
+			if a, ok := t.r.(attemptAbandoner); ok {
+				a.abandonAttempt()
+			}
+			t.complete()
+			t.stats.reqFailedCount++
+			return nil
+		}
 		t.stats.attempts++
-		return t.server.sendRequest(time, t)
+		t.currentAttempt++
+		t.firstAwaited, t.failed = t.currentAttempt, nil
+		if delay == 0 {
+			return t.send(t_)
+		}
+		return []sim.Event{
+			{
+				Time:    t_ + delay,
+				Handler: handler(t.client, "resend"),
+				Payload: attemptRef{Call: t.id, Attempt: t.currentAttempt},
+			},
+		}
 	}
 
 	// request failed after exhausting all attempts
+	t.complete()
 	t.stats.reqFailedCount++
 
 	return nil
 }
 
+// recordFailure records the failure of the current attempts with the retrier, and
+// returns its class
+func (t *call) recordFailure(err error) errorClass {
+	class := recordFailure(t.r, err)
+	t.stats.classFailures[class]++
+	if retryAfterOf(err) > 0 {
+		t.stats.hintedCount++
+	}
+	return class
+}
+
+// resend sends the current attempt, after the retry delay
+func (t *call) resend(t_ float64, payload interface{}) []sim.Event {
+	if t.done {
+		return nil
+	}
+	return t.send(t_)
+}
+
+// complete stops waiting for the responses to the attempts of the call, which the client
+// forgets
+func (t *call) complete() {
+	t.done = true
+	delete(t.client.calls, t.id)
+}
+
 type server struct {
+	sim.Base
+	sim *sim.Simulation
+	// client is the client the server responds to
+	client   *client
 	requests []request
-	isBusy   bool
-	stats    *stats
+	// workers is the number of requests the server processes concurrently, 1 if zero
+	workers int
+	// serving is the processing of the current request of each worker, nil for the idle
+	// workers, and jobs the number of requests the workers started processing
+	serving []*serving
+	jobs    int
+	stats   *stats
+	// failures is the mix of the classes of the failed requests, and retryAfter the
+	// least hint attached to the throttled ones, see retryAfterHint
+	failures   failureMix
+	retryAfter float64
 	// how long it takes for the server to fulfill a request (average, normally distributed)
 	requestLatency float64
+	// serviceTime is the distribution of the time it takes to fulfill a request, nil for
+	// the default: normally distributed around requestLatency
+	serviceTime distribution
+	// meanService is the moving average of the service times, see serviceEstimate
+	meanService float64
+	// limits of the queue and policy to shed the requests beyond them
+	limits queueLimits
+	// discipline is the order in which the requests in the queue are served
+	discipline queueDiscipline
+	// lastEmpty is the last time the queue was empty
+	lastEmpty float64
+	// lastQueueChange is the last time the length of the queue changed
+	lastQueueChange float64
+	// backpressure is the signal of the retry-after hints, computed from the moving
+	// average of the time between the arrivals, see observeArrival
+	backpressure     backpressureSignal
+	meanInterarrival float64
+	lastArrival      float64
+	arrivals         int
 	// the server failure rate
 	failureRate float64
+	// loadModel grows the service time and the failure probability with the load, and
+	// degradations slow the server down for a while
+	loadModel    loadModel
+	degradations []degradation
+	// sampleInterval is how often the server records its state, never if zero, and
+	// sampledSuccesses and sampledAttempts the counts at the last sample
+	sampleInterval                    float64
+	sampledSuccesses, sampledAttempts int
+	// detectCancellation makes the server skip the requests in the queue whose client
+	// does not wait for them anymore
+	detectCancellation bool
+	// crashes is the distribution of the time between two crashes, none if nil
+	crashes distribution
 }
 
 type request struct {
-	time   float64
-	client *call
+	time float64
+	// call is the id of the call the request is an attempt of, and attempt the number of
+	// the attempt
+	call    int
+	attempt int
 }
 
-func (t *server) sendRequest(t_ float64, payload interface{}) []sim.Event {
-	c := payload.(*call)
+var (
+	// errServerCrashed is the reason of the interruption of the requests in progress when
+	// the server crashes
+	errServerCrashed = errors.New("server crashed")
+	// errRequestFailed is the reason of the failure of the requests processed by the
+	// server, according to its failure rate
+	errRequestFailed = errors.New("request failed")
+	// errCancelled is the reason of the interruption of the requests cancelled by the
+	// client
+	errCancelled = errors.New("request cancelled")
+)
 
-	t.requests = append(t.requests, request{time: t_, client: c})
-	if !t.isBusy {
-		return []sim.Event{
-			{
-				Time:        t_,
-				CallbackFun: t.processRequest,
-				Payload:     nil,
-			},
+func (t *server) sendRequest(t_ float64, c *call) []sim.Event {
+	t.observeArrival(t_)
+	t.queueChanged(t_)
+	var events []sim.Event
+	for _, rejected := range t.admit(request{time: t_, call: c.id, attempt: c.currentAttempt}) {
+		events = append(events, t.reject(t_, rejected))
+	}
+	if t.idleWorker() != -1 && len(t.requests) > 0 {
+		events = append(events, sim.Event{
+			Time:    t_,
+			Handler: handler(t, "process"),
+			Payload: nil,
+		})
+	}
+	return events
+}
+
+// idleWorker returns the first idle worker, -1 if they are all busy
+func (t *server) idleWorker() int {
+	if t.serving == nil {
+		t.serving = make([]*serving, max(t.workers, 1))
+		t.stats.workerBusyTime = make([]float64, len(t.serving))
+	}
+	for i, s := range t.serving {
+		if s == nil {
+			return i
 		}
 	}
-	return nil
+	return -1
+}
+
+// classErrors is the reason of the failures of the server by class
+var classErrors = [...]error{
+	serverError:       errRequestFailed,
+	throttled:         errRejected,
+	clientError:       errBadRequest,
+	timedOut:          errServerTimeout,
+	connectionRefused: errConnectionRefused,
 }
 
-// server can process one request at the time
+// failure returns the failure response to a request failed with class
+func (t *server) failure(class errorClass) *failure {
+	return &failure{Class: class, RetryAfter: t.retryAfterHint(class)}
+}
+
+// respond returns the event of the response to r, a failure unless f is nil
+func (t *server) respond(t_ float64, r request, f *failure) sim.Event {
+	kind := "success"
+	if f != nil {
+		kind = "failure"
+	}
+	return sim.Event{
+		Time:    t_,
+		Handler: handler(t.client, kind),
+		Payload: response{Call: r.call, Attempt: r.attempt, Sent: r.time, Failure: f},
+	}
+}
+
+// reject fails r right away, throttled
+func (t *server) reject(t_ float64, r request) sim.Event {
+	t.stats.rejectedCount++
+	t.sim.Tracef(t, "request sent at %.3f rejected, %d queued", r.time, len(t.requests))
+	return t.respond(t_, r, t.failure(throttled))
+}
+
+// processRequest assigns the next request in the queue, according to the queue
+// discipline, to an idle worker, if any
 func (t *server) processRequest(t_ float64, payload interface{}) []sim.Event {
-	if len(t.requests) == 0 {
-		t.isBusy = false
+	worker := t.idleWorker()
+	if worker == -1 || len(t.requests) == 0 {
 		return nil
 	}
 
-	var req request
-	req, t.requests = t.requests[0], t.requests[1:]
-	t.isBusy = true
+	t.queueChanged(t_)
+	req, dropped, ok := t.dequeue(t_)
+	for ok && t.detectCancellation && !t.client.waitingFor(req) {
+		t.stats.cancelledCount++
+		t.sim.Tracef(t, "skipping cancelled request sent at %.3f", req.time)
+		var more []request
+		req, more, ok = t.dequeue(t_)
+		dropped = append(dropped, more...)
+	}
+	var events []sim.Event
+	for _, r := range dropped {
+		events = append(events, t.reject(t_, r))
+	}
+	if !ok {
+		return events
+	}
+
+	serviceTime := t.serviceTime
+	if serviceTime == nil {
+		serviceTime = normal(t.requestLatency, 0.1)
+	}
+	requestComputeTime := serviceTime(t.sim.Rand()) * t.slowdown(t_)
+	failed := t.sim.Rand().Float64() < t.failureProbability()
+	class := serverError
+	if failed {
+		class = t.failures.draw(t.sim.Rand())
+	}
+	if failed && class == connectionRefused {
+		// the request fails right away, without using the worker
+		t.stats.refusedCount++
+		t.sim.Tracef(t, "refusing request sent at %.3f, %d queued", req.time, len(t.requests))
+		return append(events,
+			t.respond(t_, req, t.failure(class)),
+			sim.Event{Time: t_, Handler: handler(t, "process"), Payload: nil})
+	}
+	t.stats.queueWait(t_ - req.time)
+	t.sim.Tracef(t, "serving request sent at %.3f, %d queued", req.time, len(t.requests))
 
-	requestComputeTime := math.Abs(mathrand.NormFloat64()*0.1 + t.requestLatency)
-	// request is done at requestEndTime
-	requestEndTime := t_ + requestComputeTime
+	// request is done at requestComputeTime from now, unless it is interrupted before
+	t.jobs++
+	t.serving[worker] = &serving{
+		req:     req,
+		failed:  failed,
+		class:   class,
+		worker:  worker,
+		started: t_,
+		job:     t.jobs,
+	}
+	t.sim.Schedule(sim.Event{
+		Time:    t_ + requestComputeTime,
+		Handler: handler(t, "done"),
+		Payload: job{Worker: worker, Job: t.jobs},
+	})
 
-	// failure rate
-	if mathrand.Float64() < t.failureRate {
-		return []sim.Event{
-			{
-				Time:        requestEndTime,
-				CallbackFun: req.client.callFailed,
-				Payload:     &req,
-			},
-			{
-				Time:        requestEndTime,
-				CallbackFun: t.processRequest,
-				Payload:     nil,
-			},
-		}
+	return events
+}
+
+// serving is the processing of a request by a worker
+type serving struct {
+	req request
+	// whether the request fails, according to the server failure rate, and how
+	failed bool
+	class  errorClass
+	// worker processing the request
+	worker int
+	// started is when the server started processing the request
+	started float64
+	// job identifies the processing in the events of its completion and interruption
+	job int
+	// interrupted is true once the processing was interrupted, and interruption is why
+	interrupted  bool
+	interruption failure
+}
+
+// job is the payload of the events of the processing of a request
+type job struct {
+	Worker, Job int
+}
+
+// release makes the worker of s idle, accounting for the time it was busy
+func (t *server) release(s *serving, t_ float64) {
+	t.serving[s.worker] = nil
+	t.stats.workerBusyTime[s.worker] += t_ - s.started
+	t.stats.busyTime += t_ - s.started
+	if !t.client.waitingFor(s.req) {
+		// nobody is waiting for the response
+		t.stats.wastedWork += t_ - s.started
 	}
+	// the weight of the last service time in the moving average
+	const alpha = 0.1
+	t.meanService = alpha*(t_-s.started) + (1-alpha)*t.serviceEstimate()
+}
 
+// queueChanged accumulates the area under the queue length, before it changes at t_
+func (t *server) queueChanged(t_ float64) {
+	if len(t.requests) == 0 {
+		t.lastEmpty = t_
+	}
+	t.stats.queueArea += float64(len(t.requests)) * (t_ - t.lastQueueChange)
+	t.lastQueueChange = t_
+}
+
+func (t *server) requestDone(t_ float64, payload interface{}) []sim.Event {
+	j := payload.(job)
+	s := t.serving[j.Worker]
+	if s == nil || s.job != j.Job || s.interrupted {
+		// the processing was interrupted before
+		return nil
+	}
+	t.release(s, t_)
+	t.sim.Tracef(t, "request sent at %.3f done, failed: %v", s.req.time, s.failed)
+
+	var f *failure
+	if s.failed {
+		f = t.failure(s.class)
+	}
 	return []sim.Event{
+		t.respond(t_, s.req, f),
 		{
-			Time:        requestEndTime,
-			CallbackFun: req.client.callSuccess,
-			Payload:     &req,
+			Time:    t_,
+			Handler: handler(t, "process"),
+			Payload: nil,
 		},
+	}
+}
+
+// interrupt interrupts the processing of the request of worker, for the reason f. It
+// returns false, and does nothing, if the worker is idle or its processing was already
+// interrupted. The request fails at the current time, see requestInterrupted.
+func (t *server) interrupt(worker int, f failure) bool {
+	s := t.serving[worker]
+	if s == nil || s.interrupted {
+		return false
+	}
+	s.interrupted, s.interruption = true, f
+	t.sim.Schedule(sim.Event{
+		Time:    t.sim.Now(),
+		Handler: handler(t, "interrupted"),
+		Payload: job{Worker: worker, Job: s.job},
+	})
+	return true
+}
+
+// requestInterrupted fails the request whose processing was interrupted
+func (t *server) requestInterrupted(t_ float64, payload interface{}) []sim.Event {
+	s := t.serving[payload.(job).Worker]
+	t.release(s, t_)
+	if !s.interruption.Cancelled {
+		t.stats.interruptedCount++
+	}
+	t.sim.Tracef(t, "request sent at %.3f interrupted: %v", s.req.time, s.interruption.err())
+
+	return []sim.Event{
+		t.respond(t_, s.req, &s.interruption),
 		{
-			Time:        requestEndTime,
-			CallbackFun: t.processRequest,
-			Payload:     nil,
+			Time:    t_,
+			Handler: handler(t, "process"),
+			Payload: nil,
 		},
 	}
 }
 
-func (t *server) startServer(t_ float64, payload interface{}) []sim.Event {
-	return []sim.Event{
+// cancel removes from the queue the given attempt of c, or interrupts its processing
+func (t *server) cancel(c *call, attempt int) {
+	for i, r := range t.requests {
+		if r.call == c.id && r.attempt == attempt {
+			t.queueChanged(t.sim.Now())
+			t.requests = append(t.requests[:i:i], t.requests[i+1:]...)
+			t.stats.cancelledByClientCount++
+			return
+		}
+	}
+	for i, s := range t.serving {
+		if s != nil && s.req.call == c.id && s.req.attempt == attempt {
+			if t.interrupt(i, failure{Cancelled: true}) {
+				t.stats.cancelledByClientCount++
+			}
+			return
+		}
+	}
+}
+
+// crash interrupts the requests in progress, if any, which fail. The server restarts
+// right away with the next requests in the queue, and crashes again after a time drawn
+// from crashes, if not nil.
+func (t *server) crash(t_ float64, payload interface{}) []sim.Event {
+	t.stats.crashCount++
+	for i := range t.serving {
+		t.interrupt(i, failure{Crashed: true})
+	}
+	return t.scheduleCrash(t_)
+}
+
+// scheduleCrash returns the event of the next crash after t_, if any before the end of
+// the simulation
+func (t *server) scheduleCrash(t_ float64) []sim.Event {
+	if t.crashes == nil {
+		return nil
+	}
+	next := t_ + t.crashes(t.sim.Rand())
+	if next > t.sim.MaxTime {
+		return nil
+	}
+	return []sim.Event{{Time: next, Handler: handler(t, "crash")}}
+}
+
+// Start starts processing requests
+func (t *server) Start(s *sim.Simulation) []sim.Event {
+	t.sim = s
+	t.register(s)
+	events := []sim.Event{
 		{
-			Time:        t_,
-			CallbackFun: t.processRequest,
-			Payload:     nil,
+			Time:    s.Now(),
+			Handler: handler(t, "process"),
+			Payload: nil,
 		},
 	}
+	if t.sampleInterval > 0 {
+		events = append(events, sim.Event{Time: s.Now() + t.sampleInterval, Handler: handler(t, "sample")})
+	}
+	return append(events, t.scheduleCrash(s.Now())...)
+}
+
+// register registers with s the handlers of the events of the server
+func (t *server) register(s *sim.Simulation) {
+	s.Register(handler(t, "process"), t.processRequest)
+	s.Register(handler(t, "done"), t.requestDone)
+	s.Register(handler(t, "interrupted"), t.requestInterrupted)
+	s.Register(handler(t, "crash"), t.crash)
+	s.Register(handler(t, "sample"), t.sample)
+}
+
+func (t *server) Stats() map[string]float64 {
+	stats := map[string]float64{
+		"queued":      float64(len(t.requests)),
+		"crashes":     float64(t.stats.crashCount),
+		"interrupted": float64(t.stats.interruptedCount),
+		"rejected":    float64(t.stats.rejectedCount),
+		"refused":     float64(t.stats.refusedCount),
+		"cancelled":   float64(t.stats.cancelledCount + t.stats.cancelledByClientCount),
+		"wasted-work": t.stats.wastedWork,
+	}
+	if now := t.sim.Now(); now > 0 {
+		for i, busy := range t.stats.workerBusyTime {
+			stats[fmt.Sprintf("worker-%d-utilization", i)] = busy / now
+		}
+		stats["utilization"] = t.stats.busyTime /
+			(now * float64(max(len(t.stats.workerBusyTime), 1)))
+	}
+	return stats
 }
 
 type stats struct {
-	uniqueCalls     int
-	attempts        int
+	uniqueCalls int
+	attempts    int
+	// reqLatencies are the latencies of the successful attempts, and callLatencies the
+	// ones of the successful calls, from the first attempt, retries and hedges included
 	reqLatencies    []float64
+	callLatencies   []float64
 	reqSuccessCount int
 	reqFailedCount  int
+	// crashes of the server, and attempts whose processing they interrupted
+	crashCount       int
+	interruptedCount int
+	// attempts rejected by the server, see shedPolicy, and refused, see failureMix
+	rejectedCount int
+	refusedCount  int
+	// failures with a retry-after hint
+	hintedCount int
+	// backup attempts sent by hedging, and attempts cancelled by the client
+	hedgedCount            int
+	cancelledByClientCount int
+	// samples of the state of the server over time, see sampleInterval
+	samples []loadSample
+	// failures of the attempts by class
+	classFailures [connectionRefused + 1]int
+	// calls failed without sending any attempt, see callGate
+	shortCircuitedCount int
+	// attempts the client gave up on, and that the server skipped because of that
+	timedOutCount  int
+	cancelledCount int
+	// wastedWork is the time the server spent on attempts nobody was waiting for
+	wastedWork float64
+	// breaker is the circuit breaker of the client, if it has one
+	breaker *breaker
+	// served is the number of attempts the server started processing, which waited
+	// waitingTime in total in its queue
+	served      int
+	waitingTime float64
+	// busyTime is the time the workers of the server spent processing requests, in total
+	// and by worker
+	busyTime       float64
+	workerBusyTime []float64
+	// queueArea is the integral over time of the length of the server queue
+	queueArea float64
+	// components are the stats of the components at the end of the simulation, by path
+	components map[string]map[string]float64
 }
 
 func (t *stats) requestLatency(latency float64) {
 	t.reqLatencies = append(t.reqLatencies, latency)
 }
 
+func (t *stats) callLatency(latency float64) {
+	t.callLatencies = append(t.callLatencies, latency)
+}
+
+func (t *stats) queueWait(wait float64) {
+	t.served++
+	t.waitingTime += wait
+}
+
+// queueMetrics returns the mean queueing metrics of the server over a simulation that
+// lasted duration. The utilization is the mean utilization of the workers.
+func (t *stats) queueMetrics(duration float64) validation.Metrics {
+	m := validation.Metrics{
+		Utilization: t.busyTime / (duration * float64(max(len(t.workerBusyTime), 1))),
+		QueueLength: t.queueArea / duration,
+	}
+	if t.served > 0 {
+		m.Wait = t.waitingTime / float64(t.served)
+	}
+	return m
+}
+
 func (t *stats) getLoad() float64 {
 	return (float64(t.attempts) / float64(t.uniqueCalls)) * 100
 }
 
 func (t *stats) getp90Latency() float64 {
-	if len(t.reqLatencies) == 0 {
+	return p90(t.reqLatencies)
+}
+
+// getp90CallLatency returns the p90 latency of the calls, which unlike the one of the
+// attempts accounts for the time spent retrying and hedging
+func (t *stats) getp90CallLatency() float64 {
+	return p90(t.callLatencies)
+}
+
+func p90(latencies []float64) float64 {
+	if len(latencies) == 0 {
 		return 0
 	}
-	p90, e := mathstats.Percentile(t.reqLatencies, 90.0)
+	p90, e := mathstats.Percentile(latencies, 90.0)
 	if e != nil {
 		panic(e)
 	}
 	return p90
 }
 
-func runSimulation(s *stats, failureRate float64, factoryName retrierFactoryName) {
+// model is the configuration of a client server simulation
+type model struct {
+	failureRate float64
+	retrier     retrierFactoryName
+	// workers is the number of requests the server processes concurrently, 1 if zero
+	workers int
+	// limits of the server queue, unbounded if zero
+	limits queueLimits
+	// discipline of the server queue, FIFO if zero
+	discipline queueDiscipline
+	// attemptTimeout and timeout of the client calls, none if zero
+	attemptTimeout float64
+	timeout        float64
+	// detectCancellation makes the server skip the requests given up by the client
+	detectCancellation bool
+	// shortCircuit makes the circuit breaker fail the first attempts too
+	shortCircuit bool
+	// cancelHedges makes the hedging client cancel the losing attempts
+	cancelHedges bool
+	// failures is the mix of the classes of the server failures, and retryAfter the
+	// hint attached to the throttled ones
+	failures   failureMix
+	retryAfter float64
+	// backpressure is the signal of the retry-after hints of the server
+	backpressure backpressureSignal
+	// loadModel and degradations change the service time and the failure probability of
+	// the server over time, and sampleInterval is how often it records its state
+	loadModel      loadModel
+	degradations   []degradation
+	sampleInterval float64
+	// timeBetweenCrashes is the mean time between the crashes of the server,
+	// exponentially distributed, never if zero
+	timeBetweenCrashes float64
+	// maxTime is when the client stops sending requests, 5000 if zero
+	maxTime float64
+	// interarrival and serviceTime are the distributions of the time between two calls
+	// and of the time to serve a request, nil for the defaults of client and server
+	interarrival distribution
+	serviceTime  distribution
+	// profiler, if not nil, aggregates the profile of the simulation, and the trace of
+	// the components under the path trace is written to stderr
+	profiler *sim.Profiler
+	trace    string
+	// seed is the seed of the random number generator of the simulation
+	seed int64
+}
+
+// runModel runs the simulation of m until completion or until ctx is done, in which case
+// it returns ctx.Err() and s only contains partial stats
+func runModel(ctx context.Context, s *stats, m model) (sim.Stats, error) {
+	simulation, err := newModel(s, m)
+	if err != nil {
+		return sim.Stats{}, err
+	}
+	st, err := simulation.Run(ctx)
+	s.components = simulation.ComponentStats()
+	return st, err
+}
+
+// newModel returns the simulation of m, ready to run, whose stats are gathered in s
+func newModel(s *stats, m model) (*sim.Simulation, error) {
 	server := &server{
-		requests:       nil,
-		isBusy:         false,
-		stats:          s,
-		requestLatency: 0.5,
-		failureRate:    failureRate,
+		Base:               sim.NewBase("server", nil),
+		requests:           nil,
+		stats:              s,
+		workers:            m.workers,
+		limits:             m.limits,
+		discipline:         m.discipline,
+		detectCancellation: m.detectCancellation,
+		requestLatency:     0.5,
+		serviceTime:        m.serviceTime,
+		failureRate:        m.failureRate,
+		failures:           m.failures,
+		retryAfter:         m.retryAfter,
+		backpressure:       m.backpressure,
+		loadModel:          m.loadModel,
+		degradations:       m.degradations,
+		sampleInterval:     m.sampleInterval,
 	}
-	c := client{
+	if m.timeBetweenCrashes > 0 {
+		server.crashes = exponential(m.timeBetweenCrashes)
+	}
+	c := &client{
+		Base:               sim.NewBase("client", nil),
 		requestsPerSeconds: 1,
 		stats:              s,
 		server:             server,
-		retrierFactory:     getFactory(factoryName),
+		retrierFactory:     getFactory(m.retrier),
+		interarrival:       m.interarrival,
+		attemptTimeout:     m.attemptTimeout,
+		timeout:            m.timeout,
 	}
-	t := 0.0
-	maxTime := 5000.0
-
-	q := &sim.EventsQueue{
-		{
-			Time:        t,
-			CallbackFun: server.startServer,
-			Payload:     nil,
-		},
-		{
-			Time:        t,
-			CallbackFun: c.genLoad,
-			Payload:     nil,
-		},
+	server.client = c
+	if f, ok := c.retrierFactory.(*circuitBreakerRetrierFactory); ok {
+		f.breaker.shortCircuit = m.shortCircuit
+		s.breaker = f.breaker
+	}
+	if f, ok := c.retrierFactory.(*hedgingRetrierFactory); ok {
+		f.policy.cancel = m.cancelHedges
+	}
+	maxTime := m.maxTime
+	if maxTime == 0 {
+		maxTime = 5000.0
 	}
 
-	sim.Run(maxTime, q, func() {
-		c.stopLoadGen()
-	})
+	simulation := sim.New(maxTime, &sim.EventsQueue{}, c.stopLoadGen)
+	simulation.Seed(m.seed)
+	if m.profiler != nil {
+		simulation.Profile(m.profiler)
+	}
+	if m.trace != "" {
+		simulation.Trace(m.trace, os.Stderr)
+	}
+	for _, component := range []sim.Component{server, c} {
+		if err := simulation.Add(component); err != nil {
+			return nil, err
+		}
+	}
+	return simulation, nil
 }
 
 func main() {
+	// the model of the sweep, the failure rate and the retrier change for each point
+	m := model{workers: 1}
+	budget := flag.Duration("budget", 0,
+		"wall clock budget for the whole sweep, e.g. 30s (0 means no limit)")
+	profile := flag.Bool("profile", false,
+		"print to stderr the time spent in each callback of the simulations")
+	flag.StringVar(&m.trace, "trace", "",
+		"write to stderr the trace of the components under this path, e.g. server")
+	flag.IntVar(&m.workers, "workers", m.workers,
+		"number of requests the server processes concurrently")
+	flag.IntVar(&m.limits.capacity, "queue", 0,
+		"capacity of the server queue (0 means unbounded)")
+	shed := flag.String("shed", rejectNewest.String(),
+		"policy to shed load: reject-newest, drop-oldest, random-early-drop or deadline")
+	flag.Float64Var(&m.limits.deadline, "deadline", 0,
+		"maximum estimated wait in the queue of the deadline shed policy")
+	disciplineName := flag.String("discipline", fifo.String(),
+		"order in which the server serves the queue: fifo, lifo, adaptive-lifo or codel")
+	failuresMix := flag.String("failures", "",
+		"weights of the classes of the server failures, e.g. server-error=0.8,client-error=0.2; "+
+			"classes: server-error, throttled, client-error, timed-out, connection-refused")
+	flag.Float64Var(&m.retryAfter, "retry-after", 0,
+		"least retry-after hint of the throttled responses (0 means none)")
+	backpressureName := flag.String("backpressure", m.backpressure.String(),
+		"signal of the retry-after hints of the server failures: none, queue or utilization")
+	loadSignalName := flag.String("load-signal", queueLoad.String(),
+		"load the service time and the failure probability grow with: queue or utilization")
+	flag.Float64Var(&m.loadModel.latencyGrowth, "latency-growth", 0,
+		"growth of the service time per unit of load, e.g. 0.1 for 10% per queued request")
+	flag.Float64Var(&m.loadModel.failureGrowth, "failure-growth", 0,
+		"growth of the failure probability per unit of load")
+	flag.Func("degrade", "slow the server down for a while, as start:duration:factor, "+
+		"e.g. 1000:200:2 (repeatable)", func(s string) error {
+		d, err := parseDegradation(s)
+		if err != nil {
+			return err
+		}
+		m.degradations = append(m.degradations, d)
+		return nil
+	})
+	metastable := flag.Bool("metastable", false,
+		"run the metastable failure scenario instead of the sweep; the scenario sets the "+
+			"server and the client itself, ignoring the other flags but -budget, -profile and -trace")
+	flag.Float64Var(&m.attemptTimeout, "attempt-timeout", 0,
+		"how long the client waits for each attempt (0 means forever)")
+	flag.Float64Var(&m.timeout, "timeout", 0,
+		"how long the client waits for a call, across attempts (0 means forever)")
+	flag.BoolVar(&m.shortCircuit, "short-circuit", false,
+		"make the circuit breaker fail the first attempts too, not only the retries")
+	flag.BoolVar(&m.cancelHedges, "cancel-hedges", false,
+		"make the hedging client cancel the attempts that lost the race")
+	flag.BoolVar(&m.detectCancellation, "cancel", false,
+		"make the server skip the queued requests the client gave up on")
+	flag.Float64Var(&m.timeBetweenCrashes, "crash-every", 0,
+		"mean time between the crashes of the server (0 means it never crashes)")
+	flag.Parse()
+	var err error
+	if m.limits.policy, err = parseShedPolicy(*shed); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if m.discipline, err = parseQueueDiscipline(*disciplineName); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if m.failures, err = parseFailureMix(*failuresMix); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if m.backpressure, err = parseBackpressure(*backpressureName); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if m.loadModel.signal, err = parseLoadSignal(*loadSignalName); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if *profile {
+		m.profiler = sim.NewProfiler(nil)
+		defer m.profiler.WriteTo(os.Stderr)
+	}
+
+	// Ctrl-C stops the sweep: the charts are drawn with the points completed so far
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *budget)
+		defer cancel()
+	}
+
 	// using  a fixed seed to make the simulation deterministic across runs
 	var seed int64 = 1650543745
-	mathrand.Seed(seed)
+	m.seed = seed
+
+	if *metastable {
+		timeline, err := runMetastable(ctx, seed, m.profiler, m.trace, []retrierFactoryName{
+			fixedRetry, exponentialBackoff, retryBudget, adaptiveTokenBucket, retryAfterBackoff})
+		if err != nil {
+			// draw the strategies completed so far
+			fmt.Fprintf(os.Stderr, "metastable scenario stopped: %v\n", err)
+		}
+		drawMetastable(timeline)
+		return
+	}
 	failureRates := rangeInterval(0, 1, 0.01)
 
 	loadVsRate := loadVsFailureRateByStrategy{
@@ -268,25 +1203,74 @@ func main() {
 		failureRate:              failureRates,
 		requestLatencyByStrategy: make(map[retrierFactoryName][]float64),
 	}
+	// the state of the circuit breaker over time, at a failure rate that makes it flap
+	breakerTimeline := breakerTimeline{failureRate: 0.5}
+	// the failure rate the stats of the components are printed at, for each strategy
+	reportedFailureRate := 0.5
 
+sweep:
 	for _, retryStrategyName := range []retrierFactoryName{
-		fixedRetry, circuitBreaker, tokenBucket, tokenBucketFixedRetry} {
+		fixedRetry, circuitBreaker, tokenBucket, tokenBucketFixedRetry,
+		constantDelay, exponentialBackoff, fullJitter, equalJitter, decorrelatedJitter,
+		retryBudget, adaptiveTokenBucket, hedgeFixed, hedgePercentile, retryAfterBackoff} {
 
 		var loads []float64
 		var p90Latencies []float64
 		for _, failureRate := range failureRates {
 
 			s := &stats{}
-			runSimulation(s, failureRate, retryStrategyName)
+			m.failureRate, m.retrier = failureRate, retryStrategyName
+			if _, err := runModel(ctx, s, m); err != nil {
+				// the point being simulated is incomplete, keep the ones before it
+				fmt.Fprintf(os.Stderr, "sweep stopped at strategy %s, failure rate %.2f: %v\n",
+					retryStrategyName, failureRate, err)
+				if len(loads) > 0 {
+					loadVsRate.loadByRetryStrategy[retryStrategyName] = loads
+					latencyVsRate.requestLatencyByStrategy[retryStrategyName] = p90Latencies
+				}
+				break sweep
+			}
 
 			loads = append(loads, s.getLoad())
 			p90Latencies = append(p90Latencies, s.getp90Latency())
+			if s.breaker != nil && failureRate == breakerTimeline.failureRate {
+				breakerTimeline.transitions = s.breaker.timeline
+			}
+			if failureRate == reportedFailureRate {
+				printComponentStats(os.Stdout, retryStrategyName, failureRate, s.components)
+			}
 		}
 		loadVsRate.loadByRetryStrategy[retryStrategyName] = loads
 		latencyVsRate.requestLatencyByStrategy[retryStrategyName] = p90Latencies
 	}
 
-	draw(latencyVsRate, loadVsRate)
+	draw(latencyVsRate, loadVsRate, breakerTimeline)
+}
+
+// printComponentStats writes to w the stats of the components of the simulation of
+// strategy at failureRate, like the utilization of each worker and the wasted work
+func printComponentStats(w io.Writer, strategy retrierFactoryName, failureRate float64,
+	components map[string]map[string]float64) {
+
+	fmt.Fprintf(w, "%s, failure rate %.2f\n", strategy, failureRate)
+	// sorted, ranging over the maps would not be deterministic
+	paths := make([]string, 0, len(components))
+	for path := range components {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		names := make([]string, 0, len(components[path]))
+		for name := range components[path] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(w, "  %s:", path)
+		for _, name := range names {
+			fmt.Fprintf(w, " %s=%.3f", name, components[path][name])
+		}
+		fmt.Fprintln(w)
+	}
 }
 
 type loadVsFailureRateByStrategy struct {
@@ -298,6 +1282,13 @@ type loadVsFailureRateByStrategy struct {
 	loadByRetryStrategy map[retrierFactoryName][]float64
 }
 
+type breakerTimeline struct {
+	// failure rate of the simulation the timeline comes from
+	failureRate float64
+	// the changes of state of the circuit breaker, in time order
+	transitions []breakerTransition
+}
+
 type requestLatenciesVsFailureRateByStrategy struct {
 	// array of the failure rates used in the simulation
 	failureRate []float64
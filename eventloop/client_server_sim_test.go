@@ -1,8 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"math"
+	mathrand "math/rand"
+	"napicella.com/simulators/eventloop/validation"
+	"napicella.com/simulators/simulation"
 	"testing"
 )
 
@@ -11,14 +19,1136 @@ func TestClientServerSim(t *testing.T) {
 	RunSpecs(t, "Client Server Simulation Suite")
 }
 
+// testSeed is the seed of the random simulations of the tests
+const testSeed = 1650543745
+
+// runSeeded runs the simulation of m from testSeed, and returns its stats
+func runSeeded(m model) *stats {
+	m.seed = testSeed
+	s := &stats{}
+	_, err := runModel(context.Background(), s, m)
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+	return s
+}
+
+// newTestServer returns a server with one worker serving each request in 1, with the
+// stats s, its client, and the simulation of the server until maxTime. The events of the
+// simulation are scheduled with sendAt and callAt.
+func newTestServer(s *stats, maxTime float64) *server {
+	srv := &server{Base: sim.NewBase("server", nil), stats: s, requestLatency: 1,
+		serviceTime: deterministic(1)}
+	srv.sim = sim.New(maxTime, &sim.EventsQueue{}, nil)
+	srv.sim.Seed(testSeed)
+	srv.client = &client{Base: sim.NewBase("client", nil), sim: srv.sim, stats: s, server: srv}
+	srv.register(srv.sim)
+	srv.client.register(srv.sim)
+	return srv
+}
+
+// sendAt sends an attempt of c to srv at t
+func sendAt(srv *server, c *call, t float64) {
+	srv.client.add(c)
+	srv.sim.Schedule(sim.Event{Time: t, CallbackFun: func(t float64, payload interface{}) []sim.Event {
+		return srv.sendRequest(t, c)
+	}})
+}
+
+// callAt starts the call c to srv at t, whose first attempt is sent like the retries
+func callAt(srv *server, c *call, t float64) {
+	srv.client.add(c)
+	srv.sim.Schedule(sim.Event{Time: t, CallbackFun: func(t float64, payload interface{}) []sim.Event {
+		return c.send(t)
+	}})
+}
+
+// runTestServer runs the simulation of srv to the end, and returns its stats
+func runTestServer(srv *server) sim.Stats {
+	st, err := srv.sim.Run(context.Background())
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+	return st
+}
+
 var _ = When("failure rate is 1 (all calls fail)", func() {
 	It("the load is (number_of_retries + 1) * 100 %", func() {
-		s := &stats{}
-		failureRate := 1.0
-		runSimulation(s, failureRate, fixedRetry)
+		s := runSeeded(model{failureRate: 1, retrier: fixedRetry})
 
 		load := (float64(s.attempts) / float64(s.uniqueCalls)) * 100
 		// assumes 3 retries
 		Expect(load).To(Equal(400.0))
 	})
 })
+
+var _ = When("the server crashes while processing a request", func() {
+	It("fails the request, which the client retries", func() {
+		s := &stats{uniqueCalls: 1, attempts: 1}
+		server := newTestServer(s, 10)
+		sendAt(server, &call{r: newFixedRetrier(), stats: s}, 0)
+		server.sim.Schedule(sim.Event{Time: 0.1, CallbackFun: server.crash})
+
+		runTestServer(server)
+		Expect(s.interruptedCount).To(Equal(1))
+		Expect(s.attempts).To(Equal(2))
+		Expect(s.reqSuccessCount).To(Equal(1))
+	})
+
+	It("crashes repeatedly with a mean time between crashes", func() {
+		s := runSeeded(model{
+			maxTime:            2000,
+			retrier:            fixedRetry,
+			timeBetweenCrashes: 20,
+			serviceTime:        deterministic(0.5),
+		})
+		Expect(s.crashCount).To(BeNumerically("~", 100, 25))
+		// the server is busy half of the time
+		Expect(s.interruptedCount).To(BeNumerically("~", s.crashCount/2, s.crashCount/4))
+		Expect(s.interruptedCount).To(Equal(s.classFailures[serverError]))
+	})
+})
+
+// The server is a FIFO queue with one or more workers: with Poisson arrivals and no
+// failures, its mean metrics must match the closed-form results of queueing theory
+var _ = Describe("Queueing model validation", func() {
+	const lambda, tolerance = 0.8, 0.05
+
+	validate := func(workers int, serviceTime distribution, expected validation.Metrics) {
+		s := &stats{}
+		st, err := runModel(context.Background(), s, model{
+			seed:         testSeed,
+			maxTime:      500000,
+			workers:      workers,
+			retrier:      fixedRetry,
+			interarrival: exponential(1 / lambda),
+			serviceTime:  serviceTime,
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		observed := s.queueMetrics(st.Time)
+		Expect(validation.Check(observed, expected, tolerance)).To(Succeed())
+		// Little's law on the observed arrival rate
+		arrivals := float64(s.uniqueCalls) / st.Time
+		Expect(observed.QueueLength).To(
+			BeNumerically("~", validation.LittleL(arrivals, observed.Wait), 0.01*observed.QueueLength))
+	}
+
+	It("matches M/M/1", func() {
+		validate(1, exponential(1), validation.MM1(lambda, 1))
+	})
+
+	It("matches M/D/1", func() {
+		validate(1, deterministic(1), validation.MD1(lambda, 1))
+	})
+
+	It("matches M/G/1 with a uniform service time (Pollaczek–Khinchine)", func() {
+		// uniform on [0.5, 1.5]: mean 1, variance 1/12
+		validate(1, uniform(0.5, 1.5), validation.MG1(lambda, 1, 1.0/12))
+	})
+
+	It("matches M/M/c with a pool of workers", func() {
+		validate(4, exponential(4), validation.MMc(lambda, 0.25, 4))
+	})
+})
+
+var _ = When("the server has a pool of workers", func() {
+	It("processes as many requests concurrently, with per-worker stats", func() {
+		s := &stats{}
+		server := newTestServer(s, 10)
+		server.workers = 3
+		for i := 0; i < 4; i++ {
+			sendAt(server, &call{r: newFixedRetrier(), stats: s}, 0)
+		}
+
+		st := runTestServer(server)
+		// three requests at once, then the fourth
+		Expect(st.Time).To(Equal(2.0))
+		Expect(s.reqSuccessCount).To(Equal(4))
+		Expect(s.workerBusyTime).To(Equal([]float64{2, 1, 1}))
+		Expect(server.Stats()).To(HaveKeyWithValue("worker-0-utilization", 1.0))
+		Expect(server.Stats()).To(HaveKeyWithValue("utilization", 4.0/6))
+	})
+
+	It("reports a zero utilization if no request arrived", func() {
+		server := newTestServer(&stats{}, 10)
+		server.workers = 3
+		idle := func(t float64, payload interface{}) []sim.Event { return nil }
+		server.sim.Schedule(sim.Event{Time: 5, CallbackFun: idle})
+		runTestServer(server)
+		Expect(server.Stats()).To(HaveKeyWithValue("utilization", 0.0))
+	})
+
+	It("collects the stats of the components at the end of the simulation", func() {
+		s := runSeeded(model{maxTime: 100, workers: 2})
+		Expect(s.components).To(HaveKey("client"))
+		Expect(s.components["server"]).To(HaveKey("worker-1-utilization"))
+		Expect(s.components["server"]).To(HaveKey("wasted-work"))
+	})
+})
+
+// recordingRetrier never retries, and records the reasons of the failures
+type recordingRetrier struct {
+	errs []error
+}
+
+func (t *recordingRetrier) initCall()             {}
+func (t *recordingRetrier) recordSuccess()        {}
+func (t *recordingRetrier) recordFailure(e error) { t.errs = append(t.errs, e) }
+func (t *recordingRetrier) shouldRetry() bool     { return false }
+func (t *recordingRetrier) delay() float64        { return 0 }
+
+var _ = Describe("Bounded server queue", func() {
+	// one worker serving in 1, a queue of 2 and a request every 0.1 from 0 to 0.4: the
+	// first is served right away, the second and the third fill the queue
+	shed := func(limits queueLimits) (*stats, *recordingRetrier) {
+		s := &stats{}
+		r := &recordingRetrier{}
+		server := newTestServer(s, 10)
+		server.limits = limits
+		for i := 0; i < 5; i++ {
+			sendAt(server, &call{r: r, stats: s, started: float64(i) / 10}, float64(i)/10)
+		}
+		runTestServer(server)
+		return s, r
+	}
+
+	It("rejects the newest requests when full", func() {
+		s, r := shed(queueLimits{capacity: 2, policy: rejectNewest})
+		Expect(s.rejectedCount).To(Equal(2))
+		Expect(r.errs).To(ConsistOf(MatchError(errRejected), MatchError(errRejected)))
+		Expect(s.reqSuccessCount).To(Equal(3))
+		// the rejected requests fail right away
+		Expect(s.served).To(Equal(3))
+	})
+
+	It("drops the oldest requests when full", func() {
+		s, r := shed(queueLimits{capacity: 2, policy: dropOldest})
+		Expect(r.errs).To(ConsistOf(MatchError(errRejected), MatchError(errRejected)))
+		Expect(s.reqSuccessCount).To(Equal(3))
+		// the requests sent at 0.3 and 0.4 replaced the ones sent at 0.1 and 0.2
+		Expect(s.reqLatencies).To(ConsistOf(
+			BeNumerically("~", 1, 1e-9), BeNumerically("~", 1.7, 1e-9), BeNumerically("~", 2.6, 1e-9)))
+	})
+
+	It("rejects the requests whose estimated wait exceeds the deadline", func() {
+		// waits: 0.9 for the one sent at 0.1, estimated 2 for the others
+		s, r := shed(queueLimits{policy: rejectPastDeadline, deadline: 1.5})
+		Expect(r.errs).To(HaveLen(3))
+		Expect(s.reqSuccessCount).To(Equal(2))
+	})
+
+	It("drops requests at random before the queue is full", func() {
+		flood := func(policy shedPolicy) *stats {
+			return runSeeded(model{
+				maxTime:      5000,
+				retrier:      fixedRetry,
+				limits:       queueLimits{capacity: 10, policy: policy},
+				interarrival: exponential(0.5),
+				serviceTime:  exponential(0.6),
+			})
+		}
+		red, newest := flood(randomEarlyDrop), flood(rejectNewest)
+		Expect(red.rejectedCount).To(BeNumerically(">", newest.rejectedCount))
+		Expect(red.queueArea).To(BeNumerically("<", newest.queueArea))
+	})
+})
+
+var _ = Describe("Queue disciplines", func() {
+	type served struct{ at, sent float64 }
+
+	// serve sends a request at each of times to a server with one worker serving in 1, and
+	// returns the requests in the order they were served, parsed from the trace
+	serve := func(discipline queueDiscipline, times ...float64) ([]served, *stats) {
+		s := &stats{}
+		server := newTestServer(s, 100)
+		server.discipline = discipline
+		for _, t := range times {
+			sendAt(server, &call{r: &recordingRetrier{}, stats: s}, t)
+		}
+		trace := &bytes.Buffer{}
+		server.sim.Trace("server", trace)
+		runTestServer(server)
+
+		var order []served
+		for _, line := range bytes.Split(trace.Bytes(), []byte("\n")) {
+			var r served
+			if n, _ := fmt.Sscanf(string(line), "t=%f server: serving request sent at %f", &r.at, &r.sent); n == 2 {
+				order = append(order, r)
+			}
+		}
+		return order, s
+	}
+	// every returns the times from 0, every step, before end
+	every := func(step, end float64) []float64 {
+		var times []float64
+		for t := 0.0; t < end; t += step {
+			times = append(times, t)
+		}
+		return times
+	}
+	sent := func(order []served) []float64 {
+		var times []float64
+		for _, r := range order {
+			times = append(times, r.sent)
+		}
+		return times
+	}
+
+	It("serves the newest request first with LIFO", func() {
+		order, _ := serve(lifo, 0, 0.1, 0.2, 0.3, 0.4)
+		Expect(sent(order)).To(Equal([]float64{0, 0.4, 0.3, 0.2, 0.1}))
+	})
+
+	It("switches from FIFO to LIFO while the queue is congested with adaptive LIFO", func() {
+		// a request every 0.6 served in 1: the queue is never empty after 1.2
+		times := every(0.6, 12)
+		order, _ := serve(adaptiveLifo, times...)
+		for i, r := range order {
+			if r.at-1.2 <= codelInterval {
+				Expect(r.sent).To(BeNumerically("~", order[0].sent+0.6*float64(i), 1e-3), "FIFO at %v", r.at)
+			} else if r.at < times[len(times)-1] {
+				// the newest request, sent less than 0.6 before
+				Expect(r.sent).To(BeNumerically(">", r.at-0.6), "LIFO at %v", r.at)
+			}
+		}
+	})
+
+	It("drops the requests that waited too long with CoDel", func() {
+		order, s := serve(codel, every(0.6, 30)...)
+		Expect(s.rejectedCount).To(BeNumerically(">", 0))
+		Expect(s.rejectedCount + len(order)).To(Equal(len(every(0.6, 30))))
+		for i, r := range order {
+			Expect(r.at - r.sent).To(BeNumerically("<=", codelInterval))
+			if i > 0 && r.sent-order[i-1].sent > 0.601 {
+				// the queue was congested: the requests before r were dropped
+				Expect(r.at-r.sent).To(BeNumerically("<=", codelTarget), "at %v", r.at)
+			}
+		}
+	})
+
+	It("lowers the latency under overload", func() {
+		p90 := func(discipline queueDiscipline) float64 {
+			return runSeeded(model{
+				maxTime:      2000,
+				retrier:      fixedRetry,
+				discipline:   discipline,
+				interarrival: exponential(0.45),
+				serviceTime:  exponential(0.5),
+			}).getp90Latency()
+		}
+		Expect(p90(lifo)).To(BeNumerically("<", p90(fifo)))
+		Expect(p90(codel)).To(BeNumerically("<", p90(fifo)))
+	})
+})
+
+var _ = Describe("Client timeouts", func() {
+	// run makes a call at 0 to a server with one worker serving in serviceTime
+	run := func(c *call, serviceTime float64, detectCancellation bool) *stats {
+		c.stats = &stats{uniqueCalls: 1, attempts: 1}
+		srv := newTestServer(c.stats, 100)
+		srv.serviceTime = deterministic(serviceTime)
+		srv.detectCancellation = detectCancellation
+		callAt(srv, c, 0)
+		runTestServer(srv)
+		return c.stats
+	}
+
+	It("retries the attempts that time out, while the server still serves them", func() {
+		// attempts at 0, 0.6, 1.2 and 1.8, all served after the client gave up on them
+		s := run(&call{r: newFixedRetrier(), attemptTimeout: 0.6}, 1, false)
+		Expect(s.attempts).To(Equal(4))
+		Expect(s.timedOutCount).To(Equal(4))
+		Expect(s.reqFailedCount).To(Equal(1))
+		Expect(s.reqSuccessCount).To(Equal(0))
+		Expect(s.served).To(Equal(4))
+		Expect(s.wastedWork).To(Equal(4.0))
+	})
+
+	It("skips the attempts the client gave up on, when the server detects cancellation", func() {
+		// the attempt sent at 1.2 is still queued at 2, when the client waits for the one
+		// sent at 1.8
+		s := run(&call{r: newFixedRetrier(), attemptTimeout: 0.6}, 1, true)
+		Expect(s.attempts).To(Equal(4))
+		Expect(s.cancelledCount).To(Equal(1))
+		Expect(s.served).To(Equal(3))
+		Expect(s.wastedWork).To(Equal(3.0))
+	})
+
+	It("fails the call once past its deadline", func() {
+		r := &recordingRetrier{}
+		s := run(&call{r: r, deadline: 1.5}, 2, false)
+		Expect(r.errs).To(Equal([]error{errDeadlineExceeded}))
+		Expect(s.reqFailedCount).To(Equal(1))
+		Expect(s.wastedWork).To(Equal(2.0))
+	})
+
+	It("does not count the attempts that complete in time as wasted", func() {
+		s := run(&call{r: newFixedRetrier(), attemptTimeout: 2, deadline: 10}, 1, false)
+		Expect(s.reqSuccessCount).To(Equal(1))
+		Expect(s.timedOutCount).To(Equal(0))
+		Expect(s.wastedWork).To(Equal(0.0))
+	})
+})
+
+var _ = Describe("Backoff retriers", func() {
+	// rng is the random number generator of the jitter, seeded before each spec
+	var rng *mathrand.Rand
+	BeforeEach(func() {
+		rng = mathrand.New(mathrand.NewSource(testSeed))
+	})
+
+	// delays returns the delays of the retries of a call whose attempts all fail
+	delays := func(strategy retrierFactoryName) []float64 {
+		r := newBackoffRetrier(strategy, rng)
+		r.initCall()
+		var delays []float64
+		for {
+			r.recordFailure(errRequestFailed)
+			if !r.shouldRetry() {
+				return delays
+			}
+			delays = append(delays, r.delay())
+		}
+	}
+
+	It("wait a constant delay", func() {
+		Expect(delays(constantDelay)).To(Equal([]float64{0.5, 0.5, 0.5}))
+	})
+
+	It("double the delay at each retry", func() {
+		Expect(delays(exponentialBackoff)).To(Equal([]float64{0.5, 1, 2}))
+	})
+
+	It("cap the delay", func() {
+		r := &backoffRetrier{fixedRetrier: fixedRetrier{maxAttempts: 10}, strategy: exponentialBackoff,
+			base: 1, cap: 5}
+		r.initCall()
+		for i := 0; i < 10; i++ {
+			r.recordFailure(errRequestFailed)
+		}
+		Expect(r.delay()).To(Equal(5.0))
+	})
+
+	It("add jitter within the bounds of each strategy", func() {
+		for i := 0; i < 1000; i++ {
+			for retry, d := range delays(fullJitter) {
+				Expect(d).To(BeNumerically(">=", 0))
+				Expect(d).To(BeNumerically("<", 0.5*math.Pow(2, float64(retry))))
+			}
+			for retry, d := range delays(equalJitter) {
+				Expect(d).To(BeNumerically(">=", 0.25*math.Pow(2, float64(retry))))
+				Expect(d).To(BeNumerically("<", 0.5*math.Pow(2, float64(retry))))
+			}
+			previous := 0.5
+			for _, d := range delays(decorrelatedJitter) {
+				Expect(d).To(BeNumerically(">=", 0.5))
+				Expect(d).To(BeNumerically("<=", math.Min(8, 3*previous)))
+				previous = d
+			}
+		}
+	})
+
+	It("schedule the retries in the future", func() {
+		s := &stats{uniqueCalls: 1, attempts: 1}
+		server := newTestServer(s, 100)
+		server.failureRate = 1
+		sendAt(server, &call{r: getFactory(exponentialBackoff).get(), stats: s}, 0)
+		trace := &bytes.Buffer{}
+		server.sim.Trace("server", trace)
+		runTestServer(server)
+
+		// each attempt takes 1, then the client waits 0.5, 1 and 2
+		Expect(trace.String()).To(ContainSubstring("t=1.500 server: serving request sent at 1.500"))
+		Expect(trace.String()).To(ContainSubstring("t=3.500 server: serving request sent at 3.500"))
+		Expect(trace.String()).To(ContainSubstring("t=6.500 server: serving request sent at 6.500"))
+		Expect(s.attempts).To(Equal(4))
+	})
+})
+
+var _ = Describe("Circuit breaker", func() {
+	var now float64
+	var b *breaker
+	BeforeEach(func() {
+		now = 0
+		b = newBreaker()
+		b.now = func() float64 { return now }
+	})
+	// fail records n failed attempts, one per second
+	fail := func(n int) {
+		for i := 0; i < n; i++ {
+			now++
+			b.record(false, true)
+		}
+	}
+
+	It("opens when the failure rate in the window reaches the threshold", func() {
+		for i := 0; i < 10; i++ {
+			now++
+			b.record(false, false)
+		}
+		fail(9)
+		Expect(b.state).To(Equal(closed))
+		// 10 failures out of the last 20 attempts
+		fail(1)
+		Expect(b.state).To(Equal(open))
+		Expect(b.allow()).To(BeFalse())
+	})
+
+	It("forgets the outcomes outside of a time window", func() {
+		b.windowSize, b.windowDuration = 0, 5
+		// never more than 6 outcomes in the window, fewer than the minimum calls
+		fail(100)
+		Expect(b.state).To(Equal(closed))
+	})
+
+	It("releases the probes of the retries abandoned past the deadline of the call", func() {
+		fail(10)
+		now += b.openDuration
+		for i := 0; i < b.probes-1; i++ {
+			b.allow()
+		}
+		r := &circuitBreakerRetrier{breaker: b}
+		r.initCall()
+		c := &call{r: r, stats: &stats{}, deadline: now}
+		newTestServer(c.stats, 100).client.add(c)
+		// the last probe is granted to the retry, which would be past the deadline
+		Expect(c.retry(now, errRequestFailed)).To(BeEmpty())
+		Expect(c.done).To(BeTrue())
+		Expect(b.probesSent).To(Equal(b.probes - 1))
+		allowed, probe := b.allow()
+		Expect(allowed && probe).To(BeTrue())
+
+		// a probe of a previous half-open period is not released
+		b.record(true, true)
+		now += b.openDuration
+		b.allow()
+		b.releaseProbe(r.halfOpens)
+		Expect(b.probesSent).To(Equal(1))
+	})
+
+	It("closes after the probes succeed in half-open state", func() {
+		fail(10)
+		openedAt := now
+		now = openedAt + b.openDuration
+		for i := 0; i < b.probes; i++ {
+			allowed, probe := b.allow()
+			Expect(allowed && probe).To(BeTrue())
+		}
+		Expect(b.state).To(Equal(halfOpen))
+		// only the probes are let through
+		Expect(b.allow()).To(BeFalse())
+		for i := 0; i < b.probes; i++ {
+			b.record(true, false)
+		}
+		Expect(b.state).To(Equal(closed))
+		Expect(b.timeline).To(Equal([]breakerTransition{
+			{0, closed}, {openedAt, open}, {now, halfOpen}, {now, closed}}))
+	})
+
+	It("opens again when a probe fails, ignoring the other attempts", func() {
+		fail(10)
+		now += b.openDuration
+		b.allow()
+		b.record(false, true)
+		Expect(b.state).To(Equal(halfOpen))
+		b.record(true, true)
+		Expect(b.state).To(Equal(open))
+	})
+
+	It("suppresses the first attempts too when short-circuiting", func() {
+		run := func(shortCircuit bool) *stats {
+			return runSeeded(model{
+				maxTime:      1000,
+				failureRate:  1,
+				retrier:      circuitBreaker,
+				shortCircuit: shortCircuit,
+			})
+		}
+		s := run(false)
+		Expect(s.shortCircuitedCount).To(Equal(0))
+		Expect(s.attempts).To(BeNumerically(">=", s.uniqueCalls))
+
+		s = run(true)
+		Expect(s.shortCircuitedCount).To(BeNumerically(">", s.uniqueCalls/2))
+		Expect(s.attempts).To(BeNumerically("<", s.uniqueCalls))
+		var states []breakerState
+		for _, tr := range s.breaker.timeline {
+			states = append(states, tr.state)
+		}
+		Expect(states).To(ContainElement(halfOpen))
+	})
+})
+
+var _ = Describe("Retry budget", func() {
+	It("allows retries up to a percentage of the calls in the window, plus a floor", func() {
+		now := 0.0
+		b := newBudget()
+		b.now = func() float64 { return now }
+		for i := 0; i < 20; i++ {
+			b.deposit()
+		}
+		// 20% of 20 calls, plus 0.1 retries per second over 10 seconds
+		allowed := 0
+		for b.withdraw() {
+			allowed++
+		}
+		Expect(allowed).To(Equal(5))
+
+		// the calls and the retries expire with the window
+		now = 11
+		allowed = 0
+		for b.withdraw() {
+			allowed++
+		}
+		Expect(allowed).To(Equal(1))
+	})
+
+	It("caps the load when all the calls fail", func() {
+		s := runSeeded(model{failureRate: 1, retrier: retryBudget})
+		// about one call per second: 2 retries every 10 calls, plus 1 every 10 seconds
+		Expect(s.getLoad()).To(BeNumerically("~", 130, 5))
+	})
+})
+
+var _ = Describe("Adaptive token bucket", func() {
+	It("charges the retries by class of error, and refunds them on success", func() {
+		q := newRetryQuota()
+		Expect(q.cost(timedOut)).To(Equal(10.0))
+		Expect(q.cost(throttled)).To(Equal(5.0))
+		Expect(q.cost(serverError)).To(Equal(5.0))
+
+		now := 0.0
+		l := newRateLimiter()
+		l.now = func() float64 { return now }
+		r := newAdaptiveRetrier(q, l, nil)
+		r.initCall()
+		r.recordFailure(errAttemptTimeout)
+		Expect(r.shouldRetry()).To(BeTrue())
+		Expect(q.tokens).To(Equal(490.0))
+		r.recordSuccess()
+		Expect(q.tokens).To(Equal(500.0))
+
+		// a success at the first attempt refills a fraction of a retry
+		q.tokens = 100.5
+		r.initCall()
+		r.recordSuccess()
+		Expect(q.tokens).To(Equal(101.5))
+	})
+
+	It("stops retrying when the quota is exhausted", func() {
+		q := newRetryQuota()
+		q.tokens = 7
+		r := newAdaptiveRetrier(q, newRateLimiter(), nil)
+		r.limiter.now = func() float64 { return 0 }
+		r.initCall()
+		r.recordFailure(errRequestFailed)
+		Expect(r.shouldRetry()).To(BeTrue())
+		r.recordFailure(errRequestFailed)
+		Expect(r.shouldRetry()).To(BeFalse())
+	})
+
+	It("backs off the retries with jitter, on top of the wait for the rate limiter", func() {
+		l := newRateLimiter()
+		l.now = func() float64 { return 0 }
+		r := newAdaptiveRetrier(newRetryQuota(), l, mathrand.New(mathrand.NewSource(testSeed)))
+		r.initCall()
+		var delays []float64
+		for i := 0; i < 2; i++ {
+			r.recordFailure(errRequestFailed)
+			Expect(r.shouldRetry()).To(BeTrue())
+			delays = append(delays, r.delay())
+		}
+		// full jitter: random up to 0.5 for the first retry and up to 1 for the second
+		Expect(delays[0]).To(And(BeNumerically(">", 0), BeNumerically("<", 0.5)))
+		Expect(delays[1]).To(And(BeNumerically(">", 0), BeNumerically("<", 1)))
+		Expect(delays[0]).NotTo(Equal(delays[1]))
+	})
+
+	It("slows down the attempts after a throttle, then recovers the rate", func() {
+		now := 0.0
+		l := newRateLimiter()
+		l.now = func() float64 { return now }
+		// 10 responses per second, none throttled: no limit
+		for ; now < 10; now += 0.1 {
+			l.update(false)
+			Expect(l.acquire()).To(Equal(0.0))
+		}
+		Expect(l.measuredRate).To(BeNumerically("~", 10, 0.5))
+
+		l.update(true)
+		Expect(l.enabled).To(BeTrue())
+		Expect(l.fillRate).To(BeNumerically("~", 0.7*l.lastMaxRate, 1e-9))
+		var delayed float64
+		for i := 0; i < 20; i++ {
+			delayed += l.acquire()
+		}
+		Expect(delayed).To(BeNumerically(">", 0))
+
+		// with responses still coming at 10 per second, the rate grows back to the one
+		// before the throttle after the time window
+		for end := now + l.timeWindow + 1; now < end; now += 0.1 {
+			l.update(false)
+		}
+		Expect(l.fillRate).To(BeNumerically(">", l.lastMaxRate))
+	})
+
+	It("sheds less load than a fixed retrier from an overloaded server", func() {
+		rejected := func(strategy retrierFactoryName) int {
+			return runSeeded(model{
+				maxTime:      2000,
+				retrier:      strategy,
+				limits:       queueLimits{capacity: 5, policy: rejectNewest},
+				interarrival: exponential(0.4),
+				serviceTime:  exponential(0.5),
+			}).rejectedCount
+		}
+		Expect(rejected(adaptiveTokenBucket)).To(BeNumerically("<", rejected(fixedRetry)/2))
+	})
+})
+
+var _ = Describe("Hedging", func() {
+	// hedge makes a call at 0 to a server with two workers, the first attempt served in
+	// 3 and the others in 0.5
+	hedge := func(policy *hedgePolicy) *stats {
+		s := &stats{uniqueCalls: 1, attempts: 1}
+		serviceTimes := []float64{3, 0.5}
+		c := &call{r: (&hedgingRetrierFactory{policy: policy}).get(), stats: s}
+		srv := newTestServer(s, 100)
+		srv.workers = 2
+		srv.serviceTime = func(*mathrand.Rand) float64 {
+			d := serviceTimes[0]
+			serviceTimes = serviceTimes[1:]
+			return d
+		}
+		c.r.initCall()
+		callAt(srv, c, 0)
+		runTestServer(srv)
+		return s
+	}
+
+	It("sends a backup after the delay and takes the first success", func() {
+		s := hedge(newHedgePolicy(1, 0))
+		Expect(s.attempts).To(Equal(2))
+		Expect(s.hedgedCount).To(Equal(1))
+		Expect(s.reqSuccessCount).To(Equal(1))
+		// the backup sent at 1 completes at 1.5, the first attempt keeps the server busy
+		// until 3
+		Expect(s.callLatencies).To(Equal([]float64{1.5}))
+		Expect(s.reqLatencies).To(Equal([]float64{0.5}))
+		Expect(s.served).To(Equal(2))
+		Expect(s.wastedWork).To(Equal(3.0))
+	})
+
+	It("cancels the loser when enabled", func() {
+		policy := newHedgePolicy(1, 0)
+		policy.cancel = true
+		s := hedge(policy)
+		Expect(s.callLatencies).To(Equal([]float64{1.5}))
+		Expect(s.cancelledByClientCount).To(Equal(1))
+		Expect(s.interruptedCount).To(Equal(0))
+		Expect(s.wastedWork).To(Equal(1.5))
+	})
+
+	It("does not hedge the calls answered before the delay", func() {
+		s := hedge(newHedgePolicy(5, 0))
+		Expect(s.hedgedCount).To(Equal(0))
+		Expect(s.callLatencies).To(Equal([]float64{3.0}))
+	})
+
+	It("derives the delay from the observed latencies", func() {
+		p := newHedgePolicy(1, 90)
+		for i := 1; i < minLatencies; i++ {
+			p.observe(float64(i))
+		}
+		Expect(p.delay()).To(Equal(1.0))
+		for i := minLatencies; i <= latencyWindow+50; i++ {
+			p.observe(float64(i))
+		}
+		// the window holds the latencies from 51 to 150
+		Expect(p.delay()).To(Equal(141.0))
+	})
+
+	It("lowers the tail latency at the cost of load", func() {
+		run := func(strategy retrierFactoryName) *stats {
+			return runSeeded(model{
+				maxTime:      2000,
+				retrier:      strategy,
+				workers:      4,
+				interarrival: exponential(1),
+				serviceTime:  exponential(0.5),
+			})
+		}
+		fixed, hedged := run(fixedRetry), run(hedgePercentile)
+		Expect(hedged.getp90CallLatency()).To(BeNumerically("<", fixed.getp90CallLatency()))
+		Expect(hedged.getLoad()).To(BeNumerically(">", fixed.getLoad()))
+	})
+})
+
+// classRecordingRetrier records the classes of the failures, and retries them once
+type classRecordingRetrier struct {
+	fixedRetrier
+	classes []errorClass
+}
+
+func (t *classRecordingRetrier) recordClassifiedFailure(err error, class errorClass) {
+	t.fixedRetrier.recordFailure(err)
+	t.classes = append(t.classes, class)
+}
+
+var _ = Describe("Error classes", func() {
+	It("classifies the failures", func() {
+		Expect(classify(&responseError{class: clientError, err: errBadRequest})).To(Equal(clientError))
+		Expect(classify(errAttemptTimeout)).To(Equal(timedOut))
+		Expect(classify(errDeadlineExceeded)).To(Equal(timedOut))
+		Expect(classify(errRejected)).To(Equal(throttled))
+		Expect(classify(errServerCrashed)).To(Equal(serverError))
+		Expect(throttled.retryable()).To(BeTrue())
+		Expect(clientError.retryable()).To(BeFalse())
+	})
+
+	It("parses the failure mix", func() {
+		mix, err := parseFailureMix("server-error=0.8,connection-refused=0.2")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mix).To(Equal(failureMix{serverError: 0.8, connectionRefused: 0.2}))
+		_, err = parseFailureMix("server-error")
+		Expect(err).To(HaveOccurred())
+		_, err = parseFailureMix("teapot=1")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("draws the classes according to their weights", func() {
+		rng := mathrand.New(mathrand.NewSource(testSeed))
+		mix := failureMix{throttled: 1, clientError: 3}
+		counts := map[errorClass]int{}
+		for i := 0; i < 10000; i++ {
+			counts[mix.draw(rng)]++
+		}
+		Expect(counts).To(HaveLen(2))
+		Expect(counts[clientError]).To(BeNumerically("~", 7500, 150))
+		Expect(failureMix{}.draw(rng)).To(Equal(serverError))
+	})
+
+	// fail makes a call at 0 to a server failing all the requests with the given mix
+	fail := func(r retrier, mix failureMix) *stats {
+		s := &stats{uniqueCalls: 1, attempts: 1}
+		srv := newTestServer(s, 100)
+		srv.failureRate = 1
+		srv.failures = mix
+		srv.retryAfter = 2
+		callAt(srv, &call{r: r, stats: s}, 0)
+		runTestServer(srv)
+		return s
+	}
+
+	It("delivers the class of the failures to the retriers", func() {
+		r := &classRecordingRetrier{fixedRetrier: fixedRetrier{maxAttempts: 1}}
+		s := fail(r, failureMix{throttled: 1})
+		Expect(r.classes).To(Equal([]errorClass{throttled, throttled}))
+		Expect(s.classFailures[throttled]).To(Equal(2))
+	})
+
+	It("attaches the retry-after hint to the throttled responses", func() {
+		r := &recordingRetrier{}
+		fail(r, failureMix{throttled: 1})
+		Expect(r.errs).To(HaveLen(1))
+		var resp *responseError
+		Expect(errors.As(r.errs[0], &resp)).To(BeTrue())
+		Expect(resp.retryAfter).To(Equal(2.0))
+		Expect(r.errs[0]).To(MatchError(errRejected))
+	})
+
+	It("does not retry the client errors", func() {
+		s := fail(newFixedRetrier(), failureMix{clientError: 1})
+		Expect(s.attempts).To(Equal(1))
+		Expect(s.reqFailedCount).To(Equal(1))
+		Expect(s.classFailures[clientError]).To(Equal(1))
+	})
+
+	It("refuses the connections without processing the requests", func() {
+		s := fail(newFixedRetrier(), failureMix{connectionRefused: 1})
+		Expect(s.attempts).To(Equal(4))
+		Expect(s.refusedCount).To(Equal(4))
+		Expect(s.served).To(Equal(0))
+		Expect(s.busyTime).To(Equal(0.0))
+	})
+
+	It("does not open the circuit breaker on client errors", func() {
+		b := newBreaker()
+		b.now = func() float64 { return 0 }
+		r := &circuitBreakerRetrier{breaker: b}
+		for i := 0; i < b.minCalls; i++ {
+			r.initCall()
+			recordFailure(r, &responseError{class: clientError, err: errBadRequest})
+		}
+		Expect(b.state).To(Equal(closed))
+		for i := 0; i < b.minCalls; i++ {
+			r.initCall()
+			recordFailure(r, errRequestFailed)
+		}
+		Expect(b.state).To(Equal(open))
+	})
+})
+
+var _ = Describe("Backpressure", func() {
+	It("hints to retry once the queue drained", func() {
+		s := &stats{}
+		srv := &server{Base: sim.NewBase("server", nil), stats: s, requestLatency: 0.5,
+			backpressure: queueBackpressure}
+		srv.idleWorker()
+		Expect(srv.retryAfterHint(serverError)).To(Equal(0.0))
+		srv.serving[0] = &serving{}
+		srv.requests = make([]request, 3)
+		Expect(srv.retryAfterHint(serverError)).To(Equal(2.0))
+		Expect(srv.retryAfterHint(connectionRefused)).To(Equal(2.0))
+		Expect(srv.retryAfterHint(clientError)).To(Equal(0.0))
+	})
+
+	It("hints to retry after the wait at the estimated utilization", func() {
+		srv := &server{requestLatency: 0.9, backpressure: utilizationBackpressure, retryAfter: 2}
+		for t := 0.0; t <= 10; t++ {
+			srv.observeArrival(t)
+		}
+		Expect(srv.meanInterarrival).To(Equal(1.0))
+		Expect(srv.utilization()).To(Equal(0.9))
+		Expect(srv.retryAfterHint(serverError)).To(BeNumerically("~", 8.1, 1e-9))
+
+		srv.requestLatency = 0.5
+		Expect(srv.retryAfterHint(serverError)).To(Equal(0.0))
+		// the throttled responses have at least the fixed hint
+		Expect(srv.retryAfterHint(throttled)).To(Equal(2.0))
+
+		srv.requestLatency = 2
+		Expect(srv.retryAfterHint(serverError)).To(Equal(maxRetryAfter))
+
+		// with 2 workers at the same utilization, the wait of Erlang C is S*u²/(1-u²)
+		srv.requestLatency, srv.workers = 1.8, 2
+		Expect(srv.utilization()).To(BeNumerically("~", 0.9, 1e-9))
+		Expect(srv.retryAfterHint(serverError)).To(BeNumerically("~", 1.8*0.81/0.19, 1e-9))
+	})
+
+	It("waits the hint of the server instead of the backoff", func() {
+		r := newRetryAfterRetrier(nil)
+		r.initCall()
+		r.recordFailure(&responseError{class: throttled, retryAfter: 3, err: errRejected})
+		Expect(r.shouldRetry()).To(BeTrue())
+		Expect(r.delay()).To(Equal(3.0))
+		r.recordFailure(errRequestFailed)
+		Expect(r.delay()).To(Equal(1.0))
+		// the server asks to wait longer than the cap
+		r.recordFailure(&responseError{class: serverError, retryAfter: maxRetryAfter, err: errRequestFailed})
+		Expect(r.shouldRetry()).To(BeFalse())
+	})
+
+	It("sheds less load than the client-only strategies", func() {
+		run := func(strategy retrierFactoryName) *stats {
+			return runSeeded(model{
+				maxTime:      2000,
+				retrier:      strategy,
+				limits:       queueLimits{capacity: 5, policy: rejectNewest},
+				backpressure: utilizationBackpressure,
+				interarrival: exponential(0.4),
+				serviceTime:  exponential(0.5),
+			})
+		}
+		// at the utilization of the overloaded server the hints exceed the cap of the
+		// backoff: the calls fail instead of retrying
+		clientOnly := run(exponentialBackoff)
+		cooperative := run(retryAfterBackoff)
+		Expect(cooperative.getLoad()).To(BeNumerically("<", clientOnly.getLoad()/2))
+		Expect(cooperative.rejectedCount).To(BeNumerically("<", clientOnly.rejectedCount/2))
+		// the calls that fail on a hint past the cap cost a few percent of the successes
+		Expect(cooperative.reqSuccessCount).To(BeNumerically(">", clientOnly.reqSuccessCount*9/10))
+	})
+})
+
+var _ = Describe("Load-dependent server", func() {
+	It("slows down with the queue and during the degradations", func() {
+		srv := &server{loadModel: loadModel{latencyGrowth: 0.1},
+			degradations: []degradation{{start: 10, duration: 5, factor: 2}}}
+		Expect(srv.slowdown(0)).To(Equal(1.0))
+		srv.requests = make([]request, 5)
+		Expect(srv.slowdown(0)).To(Equal(1.5))
+		Expect(srv.slowdown(10)).To(Equal(3.0))
+		Expect(srv.slowdown(15)).To(Equal(1.5))
+		srv.workers = 5
+		Expect(srv.slowdown(0)).To(Equal(1.1))
+	})
+
+	It("fails more with the utilization", func() {
+		srv := &server{failureRate: 0.1, requestLatency: 0.5,
+			loadModel: loadModel{signal: utilizationLoad, failureGrowth: 0.5}}
+		Expect(srv.failureProbability()).To(Equal(0.1))
+		for t := 0.0; t <= 10; t++ {
+			srv.observeArrival(t)
+		}
+		Expect(srv.failureProbability()).To(Equal(0.35))
+		srv.requestLatency = 10
+		Expect(srv.failureProbability()).To(Equal(1.0))
+	})
+
+	It("parses the degradations", func() {
+		d, err := parseDegradation("1000:200:2.5")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(d).To(Equal(degradation{start: 1000, duration: 200, factor: 2.5}))
+		_, err = parseDegradation("1000:200")
+		Expect(err).To(HaveOccurred())
+		_, err = parseDegradation("1000:200:x")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("samples its state until the end of the simulation", func() {
+		s := runSeeded(model{
+			maxTime:        100,
+			retrier:        fixedRetry,
+			interarrival:   deterministic(1),
+			serviceTime:    deterministic(0.5),
+			sampleInterval: 10,
+		})
+		Expect(s.samples).To(HaveLen(10))
+		Expect(s.samples[9].time).To(Equal(100.0))
+		Expect(s.samples[1].goodput).To(BeNumerically("~", 1, 0.1))
+		Expect(s.samples[1].throughput).To(BeNumerically("~", 1, 0.1))
+	})
+
+	It("stays overloaded after the trigger of a metastable failure, unless the retries are limited", func() {
+		timeline, err := runMetastable(context.Background(), testSeed, nil, "",
+			[]retrierFactoryName{fixedRetry, adaptiveTokenBucket})
+		Expect(err).NotTo(HaveOccurred())
+		queued := func(strategy retrierFactoryName, time float64) int {
+			for _, s := range timeline.samplesByStrategy[strategy] {
+				if s.time >= time {
+					return s.queued
+				}
+			}
+			return -1
+		}
+		trigger := timeline.trigger
+		for _, strategy := range []retrierFactoryName{fixedRetry, adaptiveTokenBucket} {
+			Expect(queued(strategy, trigger.start-10)).To(BeNumerically("<", 10))
+			Expect(queued(strategy, trigger.start+trigger.duration)).To(BeNumerically(">", 100))
+		}
+		Expect(queued(fixedRetry, 2900)).To(BeNumerically(">", 1000))
+		Expect(queued(adaptiveTokenBucket, 2900)).To(BeNumerically("<", 10))
+	})
+})
+
+var _ = Describe("Snapshots of the model", func() {
+	// the client sends a call every 0.5 or so to two workers serving in 0.6, and gives up
+	// on the attempts after 10: at 2000 the queue, the timers and the crashes are pending
+	m := model{maxTime: 5000, seed: testSeed, workers: 2, failureRate: 0.1,
+		interarrival: exponential(0.5), serviceTime: exponential(0.6), attemptTimeout: 10,
+		timeBetweenCrashes: 500, sampleInterval: 100}
+
+	// snapshot runs m until 2000 and returns its snapshot, then runs it to the end and
+	// returns its stats
+	snapshot := func(m model) ([]byte, *stats) {
+		s := &stats{}
+		original, err := newModel(s, m)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = original.RunUntil(context.Background(), 2000)
+		Expect(err).NotTo(HaveOccurred())
+		var buf bytes.Buffer
+		Expect(original.Snapshot(&buf)).To(Succeed())
+		_, err = original.Run(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		return buf.Bytes(), s
+	}
+
+	// restore resumes the snapshot in a simulation of m, and returns its stats
+	restore := func(m model, snapshot []byte) *stats {
+		s := &stats{}
+		branch, err := newModel(s, m)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(branch.Restore(bytes.NewReader(snapshot))).To(Succeed())
+		Expect(branch.Now()).To(BeNumerically("<=", 2000))
+		_, err = branch.Run(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		return s
+	}
+
+	It("resumes the simulation exactly where it was, whatever the retrier", func() {
+		for _, strategy := range []retrierFactoryName{fixedRetry, circuitBreaker, tokenBucket,
+			tokenBucketFixedRetry, fullJitter, retryBudget, adaptiveTokenBucket, hedgePercentile,
+			retryAfterBackoff} {
+			m := m
+			m.retrier = strategy
+			snap, original := snapshot(m)
+			restored := restore(m, snap)
+			Expect(restored.attempts).To(Equal(original.attempts), strategy.String())
+			Expect(restored.reqSuccessCount).To(Equal(original.reqSuccessCount), strategy.String())
+			Expect(restored.crashCount).To(Equal(original.crashCount), strategy.String())
+			Expect(restored.timedOutCount).To(Equal(original.timedOutCount), strategy.String())
+			Expect(restored.callLatencies).To(Equal(original.callLatencies), strategy.String())
+			Expect(restored.samples).To(Equal(original.samples), strategy.String())
+		}
+	})
+
+	It("branches from the same warmed-up state", func() {
+		snap, original := snapshot(m)
+		// at 2000 the failure rate jumps
+		jump := func(failureRate float64) *stats {
+			m := m
+			m.failureRate = failureRate
+			s := restore(m, snap)
+			Expect(s.samples[:20]).To(Equal(original.samples[:20]))
+			return s
+		}
+		low, high := jump(0.3), jump(0.6)
+		Expect(low.getLoad()).To(BeNumerically(">", original.getLoad()))
+		Expect(high.getLoad()).To(BeNumerically(">", low.getLoad()))
+		Expect(high.reqFailedCount).To(BeNumerically(">", low.reqFailedCount))
+	})
+})
+
+var _ = Describe("Forks of the model", func() {
+	// fork runs m until 2000 and returns a fork of it, then runs it to the end and
+	// returns its stats
+	fork := func(m model) (*sim.Simulation, *stats) {
+		s := &stats{}
+		original, err := newModel(s, m)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = original.RunUntil(context.Background(), 2000)
+		Expect(err).NotTo(HaveOccurred())
+		f, err := original.Fork()
+		Expect(err).NotTo(HaveOccurred())
+		_, err = original.Run(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		return f, s
+	}
+
+	// run runs the fork f to the end, and returns its stats
+	run := func(f *sim.Simulation) *stats {
+		_, err := f.Run(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		return f.Cloned("server").(*server).stats
+	}
+
+	It("runs the same future as the original, whatever the retrier", func() {
+		for _, strategy := range []retrierFactoryName{fixedRetry, circuitBreaker, tokenBucket,
+			tokenBucketFixedRetry, fullJitter, retryBudget, adaptiveTokenBucket, hedgePercentile,
+			retryAfterBackoff} {
+			f, original := fork(model{maxTime: 5000, seed: testSeed, workers: 2,
+				failureRate: 0.1, retrier: strategy, interarrival: exponential(0.5),
+				serviceTime: exponential(0.6), attemptTimeout: 10, timeBetweenCrashes: 500})
+			forked := run(f)
+			Expect(forked.attempts).To(Equal(original.attempts), strategy.String())
+			Expect(forked.reqSuccessCount).To(Equal(original.reqSuccessCount), strategy.String())
+			Expect(forked.callLatencies).To(Equal(original.callLatencies), strategy.String())
+			Expect(f.Cloned("client").(*client).calls).To(BeEmpty(), strategy.String())
+		}
+	})
+
+	It("compares changing the retrier mid-incident with keeping it", func() {
+		// from 1500 the service is four times slower, and the attempts time out
+		m := model{maxTime: 3000, seed: testSeed, workers: 2, retrier: fixedRetry,
+			interarrival: exponential(0.5), serviceTime: exponential(0.6), attemptTimeout: 5,
+			degradations:   []degradation{{start: 1500, duration: 1000, factor: 4}},
+			sampleInterval: 100}
+		keep, _ := fork(m)
+		change, err := keep.Fork()
+		Expect(err).NotTo(HaveOccurred())
+		change.Cloned("client").(*client).useRetrier(tokenBucket)
+
+		kept, changed := run(keep), run(change)
+		Expect(changed.samples[:20]).To(Equal(kept.samples[:20]))
+		Expect(changed.attempts).To(BeNumerically("<", kept.attempts))
+		Expect(changed.samples[25].queued).To(BeNumerically("<", kept.samples[25].queued))
+	})
+})
@@ -12,7 +12,8 @@ import (
 
 func draw(
 	latencies requestLatenciesVsFailureRateByStrategy,
-	loadVsFailureRate loadVsFailureRateByStrategy) {
+	loadVsFailureRate loadVsFailureRateByStrategy,
+	timeline breakerTimeline) {
 
 	latenciesChart := drawLatencies(latencies)
 	loadChart := drawLoad(loadVsFailureRate)
@@ -21,6 +22,9 @@ func draw(
 	page.PageTitle = "Retriers"
 	page.AddCharts(loadChart)
 	page.AddCharts(latenciesChart)
+	if len(timeline.transitions) > 0 {
+		page.AddCharts(drawBreakerTimeline(timeline))
+	}
 
 	f, err := os.Create("./build/graphs/stats.html")
 	if err != nil {
@@ -116,6 +120,90 @@ func drawLoad(loadVsFailureRate loadVsFailureRateByStrategy) *charts.Line {
 	return line
 }
 
+func drawBreakerTimeline(timeline breakerTimeline) *charts.Line {
+	line := charts.NewLine()
+	line.SetGlobalOptions(
+		charts.WithTitleOpts(opts.Title{
+			Title: fmt.Sprintf("Circuit breaker state over time (failure rate %.2f)", timeline.failureRate),
+		}),
+		charts.WithXAxisOpts(opts.XAxis{
+			Name: "Time",
+			Type: "value",
+		}),
+		charts.WithYAxisOpts(opts.YAxis{
+			Name: "State",
+			Type: "category",
+			Data: []string{closed.String(), halfOpen.String(), open.String()},
+		}),
+		charts.WithDataZoomOpts(opts.DataZoom{Type: "slider"}),
+		charts.WithTooltipOpts(opts.Tooltip{Show: true, Trigger: "axis"}),
+	)
+
+	items := make([]opts.LineData, 0, len(timeline.transitions))
+	for _, tr := range timeline.transitions {
+		items = append(items, opts.LineData{Value: []interface{}{tr.time, tr.state.String()}})
+	}
+	line.SetXAxis(nil).AddSeries(circuitBreaker.String(), items,
+		charts.WithLineChartOpts(opts.LineChart{Step: true}),
+		charts.WithLineStyleOpts(opts.LineStyle{Color: getLineColorForStrategy(circuitBreaker)}),
+		charts.WithItemStyleOpts(opts.ItemStyle{Color: getLineColorForStrategy(circuitBreaker)}),
+	)
+	return line
+}
+
+// drawMetastable draws the queue length and the goodput of the server over time in the
+// metastable scenario
+func drawMetastable(timeline metastableTimeline) {
+	page := components.NewPage()
+	page.PageTitle = "Metastable failure"
+	page.AddCharts(
+		drawSamples(timeline, "Queue length", func(s loadSample) float64 { return float64(s.queued) }),
+		drawSamples(timeline, "Goodput", func(s loadSample) float64 { return s.goodput }))
+
+	f, err := os.Create("./build/graphs/metastable.html")
+	if err != nil {
+		panic(err)
+	}
+	page.Render(io.MultiWriter(f))
+}
+
+func drawSamples(timeline metastableTimeline, name string, value func(loadSample) float64) *charts.Line {
+	trigger := timeline.trigger
+	line := charts.NewLine()
+	line.SetGlobalOptions(
+		charts.WithTitleOpts(opts.Title{
+			Title: fmt.Sprintf("%s over time (server %.0fx slower from %.0f to %.0f)",
+				name, trigger.factor, trigger.start, trigger.start+trigger.duration),
+		}),
+		charts.WithXAxisOpts(opts.XAxis{
+			Name: "Time",
+			Type: "value",
+		}),
+		charts.WithYAxisOpts(opts.YAxis{
+			Name: name,
+			Type: "value",
+		}),
+		charts.WithDataZoomOpts(opts.DataZoom{Type: "slider"}),
+		charts.WithTooltipOpts(opts.Tooltip{Show: true, Trigger: "axis"}),
+		charts.WithLegendOpts(opts.Legend{Show: true, Right: "150", Orient: "vertical"}),
+	)
+
+	line.SetXAxis(nil)
+	// in the order of the strategies, for the colors and the legend to be stable
+	for _, strategyName := range timeline.strategies {
+		samples := timeline.samplesByStrategy[strategyName]
+		items := make([]opts.LineData, 0, len(samples))
+		for _, s := range samples {
+			items = append(items, opts.LineData{Value: []interface{}{s.time, value(s)}})
+		}
+		line.AddSeries(fmt.Sprintf("%s - %s", strategyName, name), items,
+			charts.WithLineStyleOpts(opts.LineStyle{Color: getLineColorForStrategy(strategyName)}),
+			charts.WithItemStyleOpts(opts.ItemStyle{Color: getLineColorForStrategy(strategyName)}),
+		)
+	}
+	return line
+}
+
 func generateLineItems(data []float64) []opts.LineData {
 	items := make([]opts.LineData, 0)
 	for i := 0; i < len(data); i++ {
@@ -144,6 +232,26 @@ func getLineColorForStrategy(retryStrategy retrierFactoryName) string {
 		return "red"
 	case tokenBucketFixedRetry:
 		return "green"
+	case constantDelay:
+		return "orange"
+	case exponentialBackoff:
+		return "purple"
+	case fullJitter:
+		return "brown"
+	case equalJitter:
+		return "teal"
+	case decorrelatedJitter:
+		return "magenta"
+	case retryBudget:
+		return "olive"
+	case adaptiveTokenBucket:
+		return "navy"
+	case hedgeFixed:
+		return "gold"
+	case hedgePercentile:
+		return "coral"
+	case retryAfterBackoff:
+		return "pink"
 	default:
 		panic(fmt.Sprintf("invalid retryStrategy name %s", retryStrategy))
 	}
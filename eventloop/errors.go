@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	mathrand "math/rand"
+	"strconv"
+	"strings"
+)
+
+// errorClass is the class of the failure of an attempt, which tells the retriers whether
+// and how to retry it
+type errorClass int
+
+const (
+	// serverError is a retryable failure of the server, like a 5xx response
+	serverError errorClass = iota
+	// throttled means the server is shedding load, like a 429 response
+	throttled
+	// clientError is a failure caused by the request, like a 4xx response: retrying it
+	// fails again
+	clientError
+	// timedOut is an attempt that took too long, on either side
+	timedOut
+	// connectionRefused is an attempt the server refused without processing it
+	connectionRefused
+)
+
+func (d errorClass) String() string {
+	return [...]string{"server-error", "throttled", "client-error", "timed-out",
+		"connection-refused"}[d]
+}
+
+func parseErrorClass(name string) (errorClass, error) {
+	for c := serverError; c <= connectionRefused; c++ {
+		if c.String() == name {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid error class %q", name)
+}
+
+// retryable returns false for the failures that fail again when retried
+func (d errorClass) retryable() bool {
+	return d != clientError
+}
+
+var (
+	// errBadRequest is the reason of the client errors of the server
+	errBadRequest = errors.New("bad request")
+	// errServerTimeout is the reason of the timeouts of the server
+	errServerTimeout = errors.New("server timed out")
+	// errConnectionRefused is the reason of the attempts the server refused
+	errConnectionRefused = errors.New("connection refused")
+)
+
+// responseError is a failure response of the server
+type responseError struct {
+	class errorClass
+	// retryAfter is how long the server asks the client to wait before retrying, zero
+	// for no hint
+	retryAfter float64
+	err        error
+}
+
+func (t *responseError) Error() string {
+	if t.retryAfter > 0 {
+		return fmt.Sprintf("%v (%v, retry after %.3f)", t.err, t.class, t.retryAfter)
+	}
+	return fmt.Sprintf("%v (%v)", t.err, t.class)
+}
+
+func (t *responseError) Unwrap() error {
+	return t.err
+}
+
+// retryAfterOf returns the retry-after hint of err, zero if none
+func retryAfterOf(err error) float64 {
+	var r *responseError
+	if errors.As(err, &r) {
+		return r.retryAfter
+	}
+	return 0
+}
+
+// failure is the reason of the failure of an attempt, as the server sends it to the
+// client: a failure response of class Class with the retry-after hint RetryAfter, unless
+// the processing of the attempt was interrupted by a crash of the server or cancelled by
+// the client. Unlike the errors, failures can be saved in the snapshots of the simulation.
+type failure struct {
+	Class              errorClass
+	RetryAfter         float64
+	Crashed, Cancelled bool
+}
+
+// err returns the error the client gets for the failure
+func (t *failure) err() error {
+	switch {
+	case t.Crashed:
+		return errServerCrashed
+	case t.Cancelled:
+		return errCancelled
+	}
+	return &responseError{class: t.Class, retryAfter: t.RetryAfter, err: classErrors[t.Class]}
+}
+
+// classify returns the class of err. The failures the server does not classify, like
+// crashes, are server errors.
+func classify(err error) errorClass {
+	var r *responseError
+	switch {
+	case errors.As(err, &r):
+		return r.class
+	case errors.Is(err, errAttemptTimeout), errors.Is(err, errDeadlineExceeded):
+		return timedOut
+	case errors.Is(err, errRejected):
+		return throttled
+	default:
+		return serverError
+	}
+}
+
+// classAwareRetrier is implemented by the retriers that treat the failures differently
+// depending on their class
+type classAwareRetrier interface {
+	// recordClassifiedFailure records a failed attempt, in place of recordFailure
+	recordClassifiedFailure(err error, class errorClass)
+}
+
+// recordFailure records the failure err with r, and returns its class
+func recordFailure(r retrier, err error) errorClass {
+	class := classify(err)
+	if c, ok := r.(classAwareRetrier); ok {
+		c.recordClassifiedFailure(err, class)
+	} else {
+		r.recordFailure(err)
+	}
+	return class
+}
+
+// failureMix is the weight of each class among the failures of the server, all server
+// errors if empty
+type failureMix map[errorClass]float64
+
+// draw returns a random class drawn from r, according to the weights of the mix
+func (t failureMix) draw(r *mathrand.Rand) errorClass {
+	var total float64
+	for c := serverError; c <= connectionRefused; c++ {
+		total += t[c]
+	}
+	if total == 0 {
+		return serverError
+	}
+	x := r.Float64() * total
+	// the classes in order, ranging over the map would not be deterministic
+	var last errorClass
+	for c := serverError; c <= connectionRefused; c++ {
+		if t[c] == 0 {
+			continue
+		}
+		if x -= t[c]; x < 0 {
+			return c
+		}
+		last = c
+	}
+	return last
+}
+
+// parseFailureMix parses a comma separated list of class=weight, e.g.
+// "server-error=0.8,client-error=0.2"
+func parseFailureMix(s string) (failureMix, error) {
+	mix := failureMix{}
+	if s == "" {
+		return mix, nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid failure weight %q, expected class=weight", item)
+		}
+		class, err := parseErrorClass(kv[0])
+		if err != nil {
+			return nil, err
+		}
+		weight, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid weight of %v: %q", class, kv[1])
+		}
+		mix[class] = weight
+	}
+	return mix, nil
+}
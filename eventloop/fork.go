@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"napicella.com/simulators/simulation"
+)
+
+// Clone returns a copy of the server for the fork f. Its client, and the breaker of its
+// stats, are the ones of the fork, set when the client is cloned.
+func (t *server) Clone(f *sim.Simulation) sim.Cloner {
+	clone := *t
+	clone.sim = f
+	clone.stats = &stats{}
+	copyState(t, &clone)
+	clone.register(f)
+	f.RegisterState(sim.Path(t), &clone)
+	return &clone
+}
+
+// Clone returns a copy of the client for the fork f, with a copy of its calls in flight
+// and of the state shared by its retriers. The server must be cloned first: newModel
+// adds it first.
+func (t *client) Clone(f *sim.Simulation) sim.Cloner {
+	clone := *t
+	clone.sim = f
+	clone.server = f.Cloned(sim.Path(t.server)).(*server)
+	clone.server.client = &clone
+	clone.stats = clone.server.stats
+	clone.retrierFactory = t.retrierFactory.clone()
+	if b, ok := clone.retrierFactory.(*circuitBreakerRetrierFactory); ok {
+		clone.stats.breaker = b.breaker
+	}
+	// before the calls are copied, for their retriers to draw from the generator of f
+	if sf, ok := clone.retrierFactory.(simulatedFactory); ok {
+		sf.start(f)
+	}
+	copyState(t, &clone)
+	clone.register(f)
+	f.RegisterState(sim.Path(t), &clone)
+	// the fork stops generating load at its max time, like the original
+	f.SetTimeOverCallback(clone.stopLoadGen)
+	return &clone
+}
+
+// copyState copies the state of from to to, its copy in a fork
+func copyState(from sim.Component, to checkpointer) {
+	b, err := save(from.(checkpointer))
+	if err == nil {
+		err = load(to, b)
+	}
+	if err != nil {
+		panic(fmt.Sprintf("cannot clone %s: %v", sim.Path(from), err))
+	}
+}
+
+// The copies of the factories share nothing with them but their configuration: the state
+// of the copies is loaded by client.Clone.
+
+func (t *fixedRetrierFactory) clone() retrierFactory {
+	return &fixedRetrierFactory{}
+}
+
+func (t *backoffRetrierFactory) clone() retrierFactory {
+	clone := *t
+	return &clone
+}
+
+func (t *retryAfterRetrierFactory) clone() retrierFactory {
+	clone := *t
+	return &clone
+}
+
+func (t *circuitBreakerRetrierFactory) clone() retrierFactory {
+	b := *t.breaker
+	return &circuitBreakerRetrierFactory{breaker: &b}
+}
+
+func (t *retryBudgetFactory) clone() retrierFactory {
+	b := *t.budget
+	return &retryBudgetFactory{budget: &b}
+}
+
+func (t *adaptiveRetrierFactory) clone() retrierFactory {
+	q, l := *t.quota, *t.limiter
+	return &adaptiveRetrierFactory{quota: &q, limiter: &l}
+}
+
+func (t *hedgingRetrierFactory) clone() retrierFactory {
+	p := *t.policy
+	// sorted is only a buffer
+	p.sorted = nil
+	return &hedgingRetrierFactory{policy: &p}
+}
+
+func (t *tokenBucketFactory) clone() retrierFactory {
+	return &tokenBucketFactory{}
+}
+
+func (t *tokenBucketFixedRetrierFactory) clone() retrierFactory {
+	return &tokenBucketFixedRetrierFactory{}
+}
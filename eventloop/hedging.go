@@ -0,0 +1,62 @@
+package main
+
+import "sort"
+
+// hedgePolicy decides when a hedging client sends the backup of an attempt: after a
+// fixed delay or, if percentile is positive, after the given percentile of the latency
+// of the last latencyWindow successful attempts
+type hedgePolicy struct {
+	// fixedDelay is the delay of the backups, also used for the percentile policy until
+	// enough latencies are observed
+	fixedDelay float64
+	percentile float64
+	maxHedges  int
+	// cancel makes the client cancel the attempts in flight after the first success
+	cancel bool
+
+	// latencies is a ring buffer of the last latencies, next is where the next one goes
+	latencies []float64
+	next      int
+	// percentileDelay is the percentile of the latencies, computed when they change
+	// rather than for every attempt; sorted is the buffer it is computed in
+	percentileDelay float64
+	sorted          []float64
+}
+
+// latencyWindow is the number of latencies the percentile of the hedge delay is
+// computed on, and minLatencies how many are needed to start using it
+const (
+	latencyWindow = 100
+	minLatencies  = 10
+)
+
+func newHedgePolicy(fixedDelay, percentile float64) *hedgePolicy {
+	return &hedgePolicy{fixedDelay: fixedDelay, percentile: percentile, maxHedges: 1}
+}
+
+func (t *hedgePolicy) delay() float64 {
+	if t.percentile <= 0 || len(t.latencies) < minLatencies {
+		return t.fixedDelay
+	}
+	return t.percentileDelay
+}
+
+// observe records the latency of a successful attempt, and recomputes the percentile
+func (t *hedgePolicy) observe(latency float64) {
+	if len(t.latencies) < latencyWindow {
+		t.latencies = append(t.latencies, latency)
+	} else {
+		t.latencies[t.next] = latency
+		t.next = (t.next + 1) % latencyWindow
+	}
+	if t.percentile <= 0 || len(t.latencies) < minLatencies {
+		return
+	}
+	t.sorted = append(t.sorted[:0], t.latencies...)
+	sort.Float64s(t.sorted)
+	i := int(t.percentile / 100 * float64(len(t.sorted)))
+	if i >= len(t.sorted) {
+		i = len(t.sorted) - 1
+	}
+	t.percentileDelay = t.sorted[i]
+}
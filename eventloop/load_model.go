@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"napicella.com/simulators/simulation"
+	"strconv"
+	"strings"
+)
+
+// loadSignal is the measure of the load of the server its load model grows the service
+// time and the failure probability with
+type loadSignal int
+
+const (
+	// queueLoad is the number of queued requests per worker
+	queueLoad loadSignal = iota
+	// utilizationLoad is the estimated utilization of the server, see utilization
+	utilizationLoad
+)
+
+func (d loadSignal) String() string {
+	return [...]string{"queue", "utilization"}[d]
+}
+
+func parseLoadSignal(name string) (loadSignal, error) {
+	for l := queueLoad; l <= utilizationLoad; l++ {
+		if l.String() == name {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid load signal %q", name)
+}
+
+// loadModel makes the service time and the failure probability of the server grow with
+// its load, like the contention of an overloaded server does. The zero value keeps them
+// constant.
+type loadModel struct {
+	signal loadSignal
+	// latencyGrowth is the growth of the service time per unit of load, e.g. 0.1 makes
+	// it 10% longer per queued request with queueLoad
+	latencyGrowth float64
+	// failureGrowth is the growth of the failure probability per unit of load
+	failureGrowth float64
+}
+
+// degradation is a capacity degradation of the server: from start for duration, the
+// service times are factor times longer
+type degradation struct {
+	start, duration, factor float64
+}
+
+// parseDegradation parses a degradation written as start:duration:factor, e.g.
+// "1000:200:2"
+func parseDegradation(s string) (degradation, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return degradation{}, fmt.Errorf("invalid degradation %q, expected start:duration:factor", s)
+	}
+	var values [3]float64
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil || v < 0 {
+			return degradation{}, fmt.Errorf("invalid degradation %q: %q", s, p)
+		}
+		values[i] = v
+	}
+	return degradation{start: values[0], duration: values[1], factor: values[2]}, nil
+}
+
+// load returns the load of the server, according to the signal of its load model
+func (t *server) load() float64 {
+	if t.loadModel.signal == utilizationLoad {
+		return t.utilization()
+	}
+	return float64(len(t.requests)) / float64(max(t.workers, 1))
+}
+
+// slowdown returns how many times longer than its service time a request started at t_
+// takes, because of the load and of the capacity degradations
+func (t *server) slowdown(t_ float64) float64 {
+	f := 1 + t.loadModel.latencyGrowth*t.load()
+	for _, d := range t.degradations {
+		if t_ >= d.start && t_ < d.start+d.duration {
+			f *= d.factor
+		}
+	}
+	return f
+}
+
+// failureProbability returns the probability that a request fails, the failure rate of
+// the server grown with the load
+func (t *server) failureProbability() float64 {
+	if t.loadModel.failureGrowth == 0 {
+		return t.failureRate
+	}
+	return math.Min(1, t.failureRate+t.loadModel.failureGrowth*t.load())
+}
+
+// loadSample is the state of the server at a point in time
+type loadSample struct {
+	time   float64
+	queued int
+	// goodput and throughput are the calls succeeded and the attempts sent per unit of
+	// time, since the previous sample
+	goodput, throughput float64
+}
+
+// sample records the state of the server, every sampleInterval until the end of the
+// simulation
+func (t *server) sample(t_ float64, payload interface{}) []sim.Event {
+	t.stats.samples = append(t.stats.samples, loadSample{
+		time:       t_,
+		queued:     len(t.requests),
+		goodput:    float64(t.stats.reqSuccessCount-t.sampledSuccesses) / t.sampleInterval,
+		throughput: float64(t.stats.attempts-t.sampledAttempts) / t.sampleInterval,
+	})
+	t.sampledSuccesses, t.sampledAttempts = t.stats.reqSuccessCount, t.stats.attempts
+	if t_+t.sampleInterval > t.sim.MaxTime {
+		return nil
+	}
+	return []sim.Event{{Time: t_ + t.sampleInterval, Handler: handler(t, "sample")}}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"napicella.com/simulators/simulation"
+)
+
+// metastableTrigger slows the server of the metastable scenario down three times for a
+// while, which makes it overloaded
+var metastableTrigger = degradation{start: 1000, duration: 200, factor: 3}
+
+// metastableScenario is a server at half its capacity, whose service time grows with its
+// queue, hit by metastableTrigger. The attempts timing out while the server is slow are
+// retried, and the retries keep the server overloaded after the trigger is removed,
+// unless the retry strategy limits them. The scenario is calibrated for this trigger: it
+// overrides the configuration of the server and the client given with the flags, e.g.
+// -workers, -queue, -discipline or -latency-growth.
+func metastableScenario(strategy retrierFactoryName) model {
+	return model{
+		retrier:        strategy,
+		maxTime:        3000,
+		attemptTimeout: 10,
+		interarrival:   exponential(1),
+		serviceTime:    exponential(0.5),
+		loadModel:      loadModel{signal: queueLoad, latencyGrowth: 0.005},
+		degradations:   []degradation{metastableTrigger},
+		backpressure:   utilizationBackpressure,
+		sampleInterval: 10,
+	}
+}
+
+type metastableTimeline struct {
+	// trigger is the degradation of the server
+	trigger degradation
+	// strategies are the retry strategies simulated, in order, and samplesByStrategy the
+	// samples of the state of the server over time with each of them
+	strategies        []retrierFactoryName
+	samplesByStrategy map[retrierFactoryName][]loadSample
+}
+
+// runMetastable runs the metastable scenario with each strategy, from the same seed. The
+// simulations are profiled with profiler, if not nil, and traced like model.trace.
+func runMetastable(ctx context.Context, seed int64, profiler *sim.Profiler, trace string,
+	strategies []retrierFactoryName) (metastableTimeline, error) {
+
+	timeline := metastableTimeline{
+		trigger:           metastableTrigger,
+		samplesByStrategy: make(map[retrierFactoryName][]loadSample),
+	}
+	for _, strategy := range strategies {
+		s := &stats{}
+		m := metastableScenario(strategy)
+		m.profiler, m.trace, m.seed = profiler, trace, seed
+		if _, err := runModel(ctx, s, m); err != nil {
+			return timeline, err
+		}
+		timeline.strategies = append(timeline.strategies, strategy)
+		timeline.samplesByStrategy[strategy] = s.samples
+	}
+	return timeline, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errRejected is the reason of the failure of the requests shed by the server, which fail
+// right away instead of waiting in the queue
+var errRejected = errors.New("request rejected")
+
+// shedPolicy decides which requests the server rejects when it is overloaded
+type shedPolicy int
+
+const (
+	// rejectNewest rejects the requests arriving when the queue is full
+	rejectNewest shedPolicy = iota
+	// dropOldest rejects the request at the head of the queue to make room for the new one
+	dropOldest
+	// randomEarlyDrop rejects the requests arriving when the queue is more than half full
+	// with a probability that grows linearly with the queue length, up to 1 when full
+	randomEarlyDrop
+	// rejectPastDeadline rejects the requests whose estimated wait in the queue exceeds
+	// the deadline
+	rejectPastDeadline
+)
+
+func (d shedPolicy) String() string {
+	return [...]string{"reject-newest", "drop-oldest", "random-early-drop", "deadline"}[d]
+}
+
+func parseShedPolicy(name string) (shedPolicy, error) {
+	for p := rejectNewest; p <= rejectPastDeadline; p++ {
+		if p.String() == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid shed policy %q", name)
+}
+
+// queueLimits configures the load shedding of the server queue
+type queueLimits struct {
+	// capacity is the maximum length of the queue, 0 for an unbounded queue
+	capacity int
+	policy   shedPolicy
+	// deadline is the maximum estimated wait of the rejectPastDeadline policy
+	deadline float64
+}
+
+// admit queues r, unless the shed policy rejects it, and returns the requests rejected:
+// either r or, to make room for it, the oldest request in the queue
+func (t *server) admit(r request) []request {
+	capacity := t.limits.capacity
+	full := capacity > 0 && len(t.requests) >= capacity
+
+	switch t.limits.policy {
+	case dropOldest:
+		if full {
+			oldest := t.requests[0]
+			t.requests = append(t.requests[1:], r)
+			return []request{oldest}
+		}
+	case randomEarlyDrop:
+		if capacity > 0 {
+			half := float64(capacity) / 2
+			if full || t.sim.Rand().Float64() < (float64(len(t.requests))-half)/half {
+				return []request{r}
+			}
+		}
+	case rejectPastDeadline:
+		if full || t.estimatedWait() > t.limits.deadline {
+			return []request{r}
+		}
+	default:
+		if full {
+			return []request{r}
+		}
+	}
+	t.requests = append(t.requests, r)
+	return nil
+}
+
+// estimatedWait estimates how long a request arriving now waits in the queue, from the
+// number of requests ahead of it and the mean service time observed so far
+func (t *server) estimatedWait() float64 {
+	if t.idleWorker() != -1 {
+		return 0
+	}
+	return float64(len(t.requests)+1) * t.serviceEstimate() / float64(len(t.serving))
+}
+
+// serviceEstimate is an exponentially weighted moving average of the service time of the
+// requests, which starts from requestLatency
+func (t *server) serviceEstimate() float64 {
+	if t.meanService == 0 {
+		return t.requestLatency
+	}
+	return t.meanService
+}
+
+// queueDiscipline is the order in which the server serves the requests in its queue
+type queueDiscipline int
+
+const (
+	// fifo serves the oldest request first
+	fifo queueDiscipline = iota
+	// lifo serves the newest request first, which is the least likely to be stale
+	lifo
+	// adaptiveLifo serves the queue in FIFO order, switching to LIFO while the queue is
+	// congested
+	adaptiveLifo
+	// codel serves the queue in FIFO order, dropping the requests that waited more than
+	// codelInterval or, while the queue is congested, more than codelTarget
+	codel
+)
+
+const (
+	// codelInterval is how long the queue must be non-empty to be congested, and the
+	// maximum wait of the requests in a queue that is not congested
+	codelInterval = 5.0
+	// codelTarget is the maximum wait of the requests in a congested queue
+	codelTarget = 0.5
+)
+
+func (d queueDiscipline) String() string {
+	return [...]string{"fifo", "lifo", "adaptive-lifo", "codel"}[d]
+}
+
+func parseQueueDiscipline(name string) (queueDiscipline, error) {
+	for d := fifo; d <= codel; d++ {
+		if d.String() == name {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid queue discipline %q", name)
+}
+
+// congested returns true if the queue has not been empty for the last codelInterval,
+// which is the signal of congestion of adaptive LIFO and CoDel as described in "Fail at
+// scale" (Maurer, 2015)
+func (t *server) congested(t_ float64) bool {
+	return len(t.requests) > 0 && t_-t.lastEmpty > codelInterval
+}
+
+// dequeue removes from the queue the next request to serve, if any, and the requests
+// dropped by CoDel. It returns false if there is no request to serve.
+func (t *server) dequeue(t_ float64) (next request, dropped []request, ok bool) {
+	newest := t.discipline == lifo || (t.discipline == adaptiveLifo && t.congested(t_))
+	if t.discipline == codel {
+		timeout := codelInterval
+		if t.congested(t_) {
+			timeout = codelTarget
+		}
+		for len(t.requests) > 0 && t_-t.requests[0].time > timeout {
+			dropped = append(dropped, t.requests[0])
+			t.requests = t.requests[1:]
+		}
+	}
+	if len(t.requests) == 0 {
+		return request{}, dropped, false
+	}
+
+	if newest {
+		last := len(t.requests) - 1
+		next, t.requests = t.requests[last], t.requests[:last]
+	} else {
+		next, t.requests = t.requests[0], t.requests[1:]
+	}
+	return next, dropped, true
+}
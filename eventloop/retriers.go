@@ -1,10 +1,49 @@
 package main
 
+import (
+	"math"
+	mathrand "math/rand"
+)
+
 type retrier interface {
 	initCall()
 	recordSuccess()
-	recordFailure()
+	// recordFailure records a failed attempt, err is the reason of the failure
+	recordFailure(err error)
 	shouldRetry() bool
+	// delay returns how long to wait before retrying, when shouldRetry returns true
+	delay() float64
+}
+
+// callGate is implemented by the retriers that can fail a call without sending its
+// first attempt
+type callGate interface {
+	allowCall() bool
+}
+
+// callHedger is implemented by the retriers that hedge the calls: they send a backup
+// attempt if no response arrives in time, and take the first success
+type callHedger interface {
+	// nextHedge returns how long to wait for a response before sending a backup attempt,
+	// zero for no backup
+	nextHedge() float64
+	// recordLatency records the latency of a successful attempt
+	recordLatency(latency float64)
+	// cancelLosers returns true if the attempts still in flight after the first success
+	// are cancelled
+	cancelLosers() bool
+}
+
+// attemptAbandoner is implemented by the retriers that must know when an attempt they
+// allowed is not sent, e.g. because it would be past the deadline of the call
+type attemptAbandoner interface {
+	abandonAttempt()
+}
+
+// callPacer is implemented by the retriers that can delay the first attempt of a call
+type callPacer interface {
+	// callDelay returns how long to wait before sending the first attempt
+	callDelay() float64
 }
 
 func newFixedRetrier() retrier {
@@ -27,7 +66,7 @@ func (t *fixedRetrier) recordSuccess() {
 	// do nothing
 }
 
-func (t *fixedRetrier) recordFailure() {
+func (t *fixedRetrier) recordFailure(err error) {
 	t.currentAttempt++
 }
 
@@ -35,36 +74,75 @@ func (t *fixedRetrier) shouldRetry() bool {
 	return t.currentAttempt <= t.maxAttempts
 }
 
-// circuitBreakerRetrier allows retries if the rate of failures is less than the maxRate.
-// If the failure rate is less than maxRate, a fixedRetrier is used to determine whether
-// or not the call should be retried (i.e. if it reached the maximum number of attempts)
+func (t *fixedRetrier) delay() float64 {
+	return 0
+}
+
+// circuitBreakerRetrier retries like a fixedRetrier, as long as the circuit breaker
+// shared by the calls allows it. Its attempts may be probes of the breaker in half-open
+// state.
 type circuitBreakerRetrier struct {
-	r        retrier
-	failures float64
-	calls    float64
-	maxRate  float64
+	r       retrier
+	breaker *breaker
+	// probe is true if the current attempt is a probe, granted in the half-open period
+	// halfOpens of the breaker
+	probe     bool
+	halfOpens int
 }
 
 func (t *circuitBreakerRetrier) initCall() {
 	t.r = newFixedRetrier()
+	t.probe = false
+}
+
+// allow asks the breaker to let the current attempt through
+func (t *circuitBreakerRetrier) allow() bool {
+	allowed, probe := t.breaker.allow()
+	t.probe, t.halfOpens = probe, t.breaker.halfOpens
+	return allowed
+}
+
+// abandonAttempt releases the probe of the current attempt, if it is one
+func (t *circuitBreakerRetrier) abandonAttempt() {
+	if t.probe {
+		t.breaker.releaseProbe(t.halfOpens)
+		t.probe = false
+	}
+}
+
+// allowCall returns false if the breaker short-circuits the first attempt of the call
+func (t *circuitBreakerRetrier) allowCall() bool {
+	if !t.breaker.shortCircuit {
+		return true
+	}
+	return t.allow()
 }
 
 func (t *circuitBreakerRetrier) recordSuccess() {
-	t.calls++
+	t.breaker.record(t.probe, false)
 	t.r.recordSuccess()
 }
 
-func (t *circuitBreakerRetrier) recordFailure() {
-	t.failures++
-	t.calls++
-	t.r.recordFailure()
+func (t *circuitBreakerRetrier) recordFailure(err error) {
+	t.recordClassifiedFailure(err, classify(err))
+}
+
+// recordClassifiedFailure does not count the client errors as failures of the breaker:
+// the server is healthy
+func (t *circuitBreakerRetrier) recordClassifiedFailure(err error, class errorClass) {
+	t.breaker.record(t.probe, class != clientError)
+	t.r.recordFailure(err)
 }
 
 func (t *circuitBreakerRetrier) shouldRetry() bool {
-	if t.failures/t.calls < t.maxRate {
-		return t.r.shouldRetry()
+	if !t.r.shouldRetry() {
+		return false
 	}
-	return false
+	return t.allow()
+}
+
+func (t *circuitBreakerRetrier) delay() float64 {
+	return t.r.delay()
 }
 
 // tokenBucketRetrier allows retrying a request as long as the number of tokens in the
@@ -83,7 +161,7 @@ func (t *tokenBucketRetrier) recordSuccess() {
 	t.numberOfTokens = min(t.numberOfTokens+1, t.maxBucketSize)
 }
 
-func (t *tokenBucketRetrier) recordFailure() {
+func (t *tokenBucketRetrier) recordFailure(err error) {
 	t.numberOfTokens = max(t.numberOfTokens-1, 0)
 }
 
@@ -91,6 +169,10 @@ func (t *tokenBucketRetrier) shouldRetry() bool {
 	return t.numberOfTokens > 0
 }
 
+func (t *tokenBucketRetrier) delay() float64 {
+	return 0
+}
+
 // tokenBucketFixedRetrier combines a tokenBucketRetrier with a fixedRetrier. That is, a
 // request is allowed to be retried a fix number of times as long as the tokens in the
 // token bucket is not zero
@@ -108,10 +190,220 @@ func (t *tokenBucketFixedRetrier) recordSuccess() {
 	t.tokenBucketRetrier.recordSuccess()
 }
 
-func (t *tokenBucketFixedRetrier) recordFailure() {
-	t.tokenBucketRetrier.recordFailure()
+func (t *tokenBucketFixedRetrier) recordFailure(err error) {
+	t.tokenBucketRetrier.recordFailure(err)
 }
 
 func (t *tokenBucketFixedRetrier) shouldRetry() bool {
 	return t.tokenBucketRetrier.shouldRetry() && t.fixedRetrier.shouldRetry()
 }
+
+func (t *tokenBucketFixedRetrier) delay() float64 {
+	return t.fixedRetrier.delay()
+}
+
+// retryBudgetRetrier retries like a fixedRetrier, as long as the retry budget shared by
+// the calls allows it
+type retryBudgetRetrier struct {
+	fixedRetrier
+	budget *budget
+}
+
+func (t *retryBudgetRetrier) initCall() {
+	t.fixedRetrier.initCall()
+	t.budget.deposit()
+}
+
+func (t *retryBudgetRetrier) shouldRetry() bool {
+	return t.fixedRetrier.shouldRetry() && t.budget.withdraw()
+}
+
+// adaptiveRetrier is the adaptive retry mode of the AWS SDKs: it retries with full
+// jitter backoff, as long as the retry quota shared by the calls has enough tokens to pay
+// for the retry, and paces all the attempts with the shared client rate limiter
+type adaptiveRetrier struct {
+	backoffRetrier
+	quota   *retryQuota
+	limiter *rateLimiter
+	// lastClass is the class of the last failure, and retryCost the cost of the last retry
+	lastClass errorClass
+	retryCost float64
+}
+
+func newAdaptiveRetrier(
+	quota *retryQuota, limiter *rateLimiter, rand *mathrand.Rand) *adaptiveRetrier {
+
+	backoff := newBackoffRetrier(fullJitter, rand).(*backoffRetrier)
+	return &adaptiveRetrier{backoffRetrier: *backoff, quota: quota, limiter: limiter}
+}
+
+func (t *adaptiveRetrier) initCall() {
+	t.backoffRetrier.initCall()
+	t.lastClass = serverError
+	t.retryCost = 0
+}
+
+func (t *adaptiveRetrier) callDelay() float64 {
+	return t.limiter.acquire()
+}
+
+func (t *adaptiveRetrier) recordSuccess() {
+	t.limiter.update(false)
+	if t.retryCost > 0 {
+		t.quota.refill(t.retryCost)
+	} else {
+		t.quota.refill(t.quota.noRetryRefill)
+	}
+}
+
+func (t *adaptiveRetrier) recordFailure(err error) {
+	t.recordClassifiedFailure(err, classify(err))
+}
+
+func (t *adaptiveRetrier) recordClassifiedFailure(err error, class errorClass) {
+	t.backoffRetrier.recordFailure(err)
+	t.limiter.update(class == throttled)
+	t.lastClass = class
+}
+
+func (t *adaptiveRetrier) shouldRetry() bool {
+	if !t.backoffRetrier.shouldRetry() {
+		return false
+	}
+	cost := t.quota.cost(t.lastClass)
+	if !t.quota.acquire(cost) {
+		return false
+	}
+	t.retryCost = cost
+	return true
+}
+
+// delay is the backoff of the retry, plus the wait for the rate limiter
+func (t *adaptiveRetrier) delay() float64 {
+	return t.backoffRetrier.delay() + t.limiter.acquire()
+}
+
+// hedgingRetrier retries like a fixedRetrier, and hedges each attempt with up to
+// maxHedges backups, after the delay of its hedging policy
+type hedgingRetrier struct {
+	fixedRetrier
+	policy *hedgePolicy
+	// hedges is the number of backups of the current attempt
+	hedges int
+}
+
+func (t *hedgingRetrier) initCall() {
+	t.fixedRetrier.initCall()
+	t.hedges = 0
+}
+
+func (t *hedgingRetrier) nextHedge() float64 {
+	if t.hedges >= t.policy.maxHedges {
+		return 0
+	}
+	t.hedges++
+	return t.policy.delay()
+}
+
+func (t *hedgingRetrier) recordFailure(err error) {
+	t.fixedRetrier.recordFailure(err)
+	// the retry is hedged again
+	t.hedges = 0
+}
+
+func (t *hedgingRetrier) recordLatency(latency float64) {
+	t.policy.observe(latency)
+}
+
+func (t *hedgingRetrier) cancelLosers() bool {
+	return t.policy.cancel
+}
+
+// retryAfterRetrier retries like an exponential backoffRetrier, waiting the retry-after
+// hint of the server instead of the backoff when the failure has one, up to the cap
+type retryAfterRetrier struct {
+	backoffRetrier
+	// hint is the retry-after hint of the last failure
+	hint float64
+}
+
+func newRetryAfterRetrier(rand *mathrand.Rand) retrier {
+	backoff := newBackoffRetrier(exponentialBackoff, rand).(*backoffRetrier)
+	return &retryAfterRetrier{backoffRetrier: *backoff}
+}
+
+func (t *retryAfterRetrier) initCall() {
+	t.backoffRetrier.initCall()
+	t.hint = 0
+}
+
+func (t *retryAfterRetrier) recordFailure(err error) {
+	t.backoffRetrier.recordFailure(err)
+	t.hint = retryAfterOf(err)
+}
+
+// shouldRetry gives up on the call when the server asks to wait longer than the cap of
+// the backoff
+func (t *retryAfterRetrier) shouldRetry() bool {
+	return t.backoffRetrier.shouldRetry() && t.hint <= t.cap
+}
+
+func (t *retryAfterRetrier) delay() float64 {
+	if t.hint > 0 {
+		return t.hint
+	}
+	return t.backoffRetrier.delay()
+}
+
+func newBackoffRetrier(strategy retrierFactoryName, rand *mathrand.Rand) retrier {
+	return &backoffRetrier{
+		fixedRetrier: fixedRetrier{maxAttempts: 3},
+		strategy:     strategy,
+		base:         0.5,
+		cap:          8,
+		rand:         rand,
+	}
+}
+
+// backoffRetrier allows retrying a fixed amount of times, like fixedRetrier, waiting
+// before each retry a delay computed by strategy, one of:
+//   - constantDelay: base
+//   - exponentialBackoff: base * 2^retry, up to cap
+//   - fullJitter: random between 0 and the exponential backoff
+//   - equalJitter: half the exponential backoff, plus a random half
+//   - decorrelatedJitter: random between base and 3 times the previous delay, up to cap
+//
+// The jitter variants are described in
+// https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/ and draw
+// from rand, the random number generator of the simulation.
+type backoffRetrier struct {
+	fixedRetrier
+	strategy  retrierFactoryName
+	base, cap float64
+	// sleep is the previous delay of decorrelatedJitter
+	sleep float64
+	rand  *mathrand.Rand
+}
+
+func (t *backoffRetrier) initCall() {
+	t.fixedRetrier.initCall()
+	t.sleep = t.base
+}
+
+func (t *backoffRetrier) delay() float64 {
+	// currentAttempt is the number of failures, 1 for the first retry
+	exponential := math.Min(t.cap, t.base*math.Pow(2, float64(t.currentAttempt-1)))
+	switch t.strategy {
+	case constantDelay:
+		return t.base
+	case fullJitter:
+		return t.rand.Float64() * exponential
+	case equalJitter:
+		return exponential/2 + t.rand.Float64()*exponential/2
+	case decorrelatedJitter:
+		t.sleep = math.Min(t.cap, t.base+t.rand.Float64()*(3*t.sleep-t.base))
+		return t.sleep
+	default:
+		return exponential
+	}
+}
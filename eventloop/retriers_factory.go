@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	mathrand "math/rand"
+	"napicella.com/simulators/simulation"
 )
 
 const (
@@ -9,12 +11,25 @@ const (
 	circuitBreaker
 	tokenBucket
 	tokenBucketFixedRetry
+	constantDelay
+	exponentialBackoff
+	fullJitter
+	equalJitter
+	decorrelatedJitter
+	retryBudget
+	adaptiveTokenBucket
+	hedgeFixed
+	hedgePercentile
+	retryAfterBackoff
 )
 
 type retrierFactoryName int
 
 func (d retrierFactoryName) String() string {
-	return [...]string{"fixed", "circuit-breaker", "token-bucket", "token-bucket-fixed"}[d]
+	return [...]string{"fixed", "circuit-breaker", "token-bucket", "token-bucket-fixed",
+		"constant-delay", "exponential-backoff", "full-jitter", "equal-jitter",
+		"decorrelated-jitter", "retry-budget", "adaptive-token-bucket", "hedge-fixed",
+		"hedge-p90", "retry-after"}[d]
 }
 
 func getFactory(name retrierFactoryName) retrierFactory {
@@ -22,11 +37,23 @@ func getFactory(name retrierFactoryName) retrierFactory {
 	case fixedRetry:
 		return &fixedRetrierFactory{}
 	case circuitBreaker:
-		return &circuitBreakerRetrierFactory{}
+		return &circuitBreakerRetrierFactory{breaker: newBreaker()}
 	case tokenBucket:
 		return &tokenBucketFactory{}
 	case tokenBucketFixedRetry:
 		return &tokenBucketFixedRetrierFactory{}
+	case adaptiveTokenBucket:
+		return &adaptiveRetrierFactory{quota: newRetryQuota(), limiter: newRateLimiter()}
+	case retryAfterBackoff:
+		return &retryAfterRetrierFactory{}
+	case hedgeFixed:
+		return &hedgingRetrierFactory{policy: newHedgePolicy(1, 0)}
+	case hedgePercentile:
+		return &hedgingRetrierFactory{policy: newHedgePolicy(1, 90)}
+	case retryBudget:
+		return &retryBudgetFactory{budget: newBudget()}
+	case constantDelay, exponentialBackoff, fullJitter, equalJitter, decorrelatedJitter:
+		return &backoffRetrierFactory{strategy: name}
 	default:
 		panic(fmt.Sprintf("invalid retrier name %s", name))
 	}
@@ -34,6 +61,18 @@ func getFactory(name retrierFactoryName) retrierFactory {
 
 type retrierFactory interface {
 	get() retrier
+	// checkpoint saves or loads the state the retriers share, see client.checkpoint
+	checkpoint(c *checkpoint)
+	// clone returns a copy of the factory for a fork, whose state is then loaded with
+	// checkpoint, see client.Clone
+	clone() retrierFactory
+}
+
+// simulatedFactory is implemented by the factories whose retriers depend on the
+// simulation, e.g. on its time or its random number generator. Start is called when the
+// client starts.
+type simulatedFactory interface {
+	start(s *sim.Simulation)
 }
 
 type fixedRetrierFactory struct{}
@@ -42,20 +81,80 @@ func (t *fixedRetrierFactory) get() retrier {
 	return newFixedRetrier()
 }
 
+type backoffRetrierFactory struct {
+	strategy retrierFactoryName
+	rand     *mathrand.Rand
+}
+
+func (t *backoffRetrierFactory) get() retrier {
+	return newBackoffRetrier(t.strategy, t.rand)
+}
+
+func (t *backoffRetrierFactory) start(s *sim.Simulation) {
+	t.rand = s.Rand()
+}
+
+// circuitBreakerRetrierFactory returns retriers sharing the same circuit breaker
 type circuitBreakerRetrierFactory struct {
-	r *circuitBreakerRetrier
+	breaker *breaker
 }
 
 func (t *circuitBreakerRetrierFactory) get() retrier {
-	if t.r == nil {
-		t.r = &circuitBreakerRetrier{
-			r:        newFixedRetrier(),
-			failures: 0,
-			calls:    0,
-			maxRate:  0.5,
-		}
-	}
-	return t.r
+	return &circuitBreakerRetrier{r: newFixedRetrier(), breaker: t.breaker}
+}
+
+func (t *circuitBreakerRetrierFactory) start(s *sim.Simulation) {
+	t.breaker.now = s.Now
+}
+
+// retryBudgetFactory returns retriers sharing the same retry budget
+type retryBudgetFactory struct {
+	budget *budget
+}
+
+func (t *retryBudgetFactory) get() retrier {
+	return &retryBudgetRetrier{fixedRetrier: fixedRetrier{maxAttempts: 3}, budget: t.budget}
+}
+
+func (t *retryBudgetFactory) start(s *sim.Simulation) {
+	t.budget.now = s.Now
+}
+
+// adaptiveRetrierFactory returns retriers sharing the same retry quota and rate limiter
+type adaptiveRetrierFactory struct {
+	quota   *retryQuota
+	limiter *rateLimiter
+	rand    *mathrand.Rand
+}
+
+func (t *adaptiveRetrierFactory) get() retrier {
+	return newAdaptiveRetrier(t.quota, t.limiter, t.rand)
+}
+
+func (t *adaptiveRetrierFactory) start(s *sim.Simulation) {
+	t.limiter.now = s.Now
+	t.rand = s.Rand()
+}
+
+type retryAfterRetrierFactory struct {
+	rand *mathrand.Rand
+}
+
+func (t *retryAfterRetrierFactory) get() retrier {
+	return newRetryAfterRetrier(t.rand)
+}
+
+func (t *retryAfterRetrierFactory) start(s *sim.Simulation) {
+	t.rand = s.Rand()
+}
+
+// hedgingRetrierFactory returns retriers sharing the same hedging policy
+type hedgingRetrierFactory struct {
+	policy *hedgePolicy
+}
+
+func (t *hedgingRetrierFactory) get() retrier {
+	return &hedgingRetrier{fixedRetrier: fixedRetrier{maxAttempts: 3}, policy: t.policy}
 }
 
 type tokenBucketFactory struct {
@@ -0,0 +1,52 @@
+package main
+
+// budget is a retry budget in the style of Finagle and gRPC, shared by the calls of a
+// client: retries are allowed as long as, over the last window, they are less than
+// percentCanRetry of the calls, plus minRetriesPerSecond. The floor lets a client with
+// little traffic retry at all.
+type budget struct {
+	// now returns the current simulation time
+	now func() float64
+
+	window              float64
+	percentCanRetry     float64
+	minRetriesPerSecond float64
+
+	// times of the calls and of the retries in the window, oldest first
+	calls, retries []float64
+}
+
+func newBudget() *budget {
+	return &budget{
+		window:              10,
+		percentCanRetry:     0.2,
+		minRetriesPerSecond: 0.1,
+	}
+}
+
+// deposit records a call
+func (t *budget) deposit() {
+	t.calls = append(t.expire(t.calls), t.now())
+}
+
+// withdraw records a retry and returns true if the budget allows it
+func (t *budget) withdraw() bool {
+	t.calls = t.expire(t.calls)
+	t.retries = t.expire(t.retries)
+	allowed := t.percentCanRetry*float64(len(t.calls)) + t.minRetriesPerSecond*t.window
+	if float64(len(t.retries)+1) > allowed {
+		return false
+	}
+	t.retries = append(t.retries, t.now())
+	return true
+}
+
+// expire removes from times the ones out of the window
+func (t *budget) expire(times []float64) []float64 {
+	now := t.now()
+	i := 0
+	for i < len(times) && now-times[i] > t.window {
+		i++
+	}
+	return times[i:]
+}
@@ -1,6 +1,9 @@
 package main
 
-import "math"
+import (
+	"math"
+	mathrand "math/rand"
+)
 
 func max(x, y int) int {
 	if x >= y {
@@ -23,3 +26,32 @@ func rangeInterval(start, end, increment float64) []float64 {
 	}
 	return res
 }
+
+// distribution returns random samples of a non-negative random variable, drawn from r:
+// the random number generator of the simulation
+type distribution func(r *mathrand.Rand) float64
+
+// normal is the absolute value of a normal random variable
+func normal(mean, stdDev float64) distribution {
+	return func(r *mathrand.Rand) float64 {
+		return math.Abs(r.NormFloat64()*stdDev + mean)
+	}
+}
+
+func exponential(mean float64) distribution {
+	return func(r *mathrand.Rand) float64 {
+		return r.ExpFloat64() * mean
+	}
+}
+
+func deterministic(value float64) distribution {
+	return func(r *mathrand.Rand) float64 {
+		return value
+	}
+}
+
+func uniform(min, max float64) distribution {
+	return func(r *mathrand.Rand) float64 {
+		return min + r.Float64()*(max-min)
+	}
+}
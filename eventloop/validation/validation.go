@@ -0,0 +1,109 @@
+// Package validation contains the closed-form results of queueing theory that the
+// simulations of single server queues are checked against.
+//
+// All the models have Poisson arrivals with rate lambda and an infinite FIFO queue. They
+// differ by the distribution of the service time: exponential (M/M/1 and, with c
+// servers, M/M/c), deterministic (M/D/1) or general (M/G/1). The results are for the
+// steady state, which exists only if the utilization is less than 1.
+package validation
+
+import (
+	"fmt"
+	"math"
+)
+
+// Metrics are the mean steady state metrics of a queue
+type Metrics struct {
+	// Utilization is the fraction of time the server is busy
+	Utilization float64
+	// Wait is the time a request spends in the queue, before being served
+	Wait float64
+	// QueueLength is the number of requests in the queue, excluding the one being served
+	QueueLength float64
+}
+
+// MG1 returns the metrics of an M/G/1 queue with arrival rate lambda and a service time
+// with the given mean and variance, using the Pollaczek–Khinchine formula for the wait
+func MG1(lambda, meanService, serviceVariance float64) Metrics {
+	rho := lambda * meanService
+	if rho >= 1 {
+		return Metrics{Utilization: 1, Wait: math.Inf(1), QueueLength: math.Inf(1)}
+	}
+	secondMoment := serviceVariance + meanService*meanService
+	wait := lambda * secondMoment / (2 * (1 - rho))
+	return Metrics{
+		Utilization: rho,
+		Wait:        wait,
+		QueueLength: LittleL(lambda, wait),
+	}
+}
+
+// MM1 returns the metrics of an M/M/1 queue with arrival rate lambda and service rate mu
+func MM1(lambda, mu float64) Metrics {
+	return MG1(lambda, 1/mu, 1/(mu*mu))
+}
+
+// MMc returns the metrics of an M/M/c queue with arrival rate lambda and c servers with
+// service rate mu each, using the Erlang C formula for the probability of waiting. The
+// utilization is the one of each server.
+func MMc(lambda, mu float64, c int) Metrics {
+	rho := lambda / (float64(c) * mu)
+	if rho >= 1 {
+		return Metrics{Utilization: 1, Wait: math.Inf(1), QueueLength: math.Inf(1)}
+	}
+	// offered load a = lambda / mu, in Erlangs: sum of a^k / k! for k < c, and a^c / c!
+	a := lambda / mu
+	sum, term := 0.0, 1.0
+	for k := 0; k < c; k++ {
+		sum += term
+		term *= a / float64(k+1)
+	}
+	erlangC := term / (1 - rho) / (sum + term/(1-rho))
+	wait := erlangC / (float64(c)*mu - lambda)
+	return Metrics{
+		Utilization: rho,
+		Wait:        wait,
+		QueueLength: LittleL(lambda, wait),
+	}
+}
+
+// MD1 returns the metrics of an M/D/1 queue with arrival rate lambda and service time d
+func MD1(lambda, d float64) Metrics {
+	return MG1(lambda, d, 0)
+}
+
+// LittleL returns the mean number of requests in a system with arrival rate lambda,
+// whose requests stay in it for a mean time w
+func LittleL(lambda, w float64) float64 {
+	return lambda * w
+}
+
+// Check returns an error listing the metrics of observed whose relative difference from
+// expected is greater than tolerance, e.g. 0.05 for 5%
+func Check(observed, expected Metrics, tolerance float64) error {
+	var drifts []string
+	for _, m := range []struct {
+		name               string
+		observed, expected float64
+	}{
+		{"utilization", observed.Utilization, expected.Utilization},
+		{"wait", observed.Wait, expected.Wait},
+		{"queue length", observed.QueueLength, expected.QueueLength},
+	} {
+		if drift := relativeDiff(m.observed, m.expected); !(drift <= tolerance) {
+			drifts = append(drifts, fmt.Sprintf("%s is %.4f, expected %.4f (%.1f%% off)",
+				m.name, m.observed, m.expected, drift*100))
+		}
+	}
+	if len(drifts) > 0 {
+		return fmt.Errorf("metrics beyond the %.1f%% tolerance: %v", tolerance*100, drifts)
+	}
+	return nil
+}
+
+func relativeDiff(observed, expected float64) float64 {
+	if expected == 0 {
+		return math.Abs(observed)
+	}
+	return math.Abs(observed-expected) / math.Abs(expected)
+}
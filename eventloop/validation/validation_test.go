@@ -0,0 +1,47 @@
+package validation
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"math"
+	"testing"
+)
+
+func TestValidation(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Validation Suite")
+}
+
+var _ = Describe("Closed-form metrics", func() {
+	It("match the textbook M/M/1 results", func() {
+		// lambda = 1, mu = 2: Wq = rho / (mu - lambda), Lq = rho^2 / (1 - rho)
+		m := MM1(1, 2)
+		Expect(m.Utilization).To(BeNumerically("~", 0.5, 1e-9))
+		Expect(m.Wait).To(BeNumerically("~", 0.5, 1e-9))
+		Expect(m.QueueLength).To(BeNumerically("~", 0.5, 1e-9))
+	})
+
+	It("match the textbook M/M/c results", func() {
+		Expect(MMc(1, 2, 1).Wait).To(BeNumerically("~", MM1(1, 2).Wait, 1e-9))
+		// lambda = 2, mu = 1, c = 3: Erlang C = 4/9, Wq = C / (c mu - lambda)
+		m := MMc(2, 1, 3)
+		Expect(m.Utilization).To(BeNumerically("~", 2.0/3, 1e-9))
+		Expect(m.Wait).To(BeNumerically("~", 4.0/9, 1e-9))
+	})
+
+	It("halve the M/M/1 wait for a deterministic service", func() {
+		Expect(MD1(1, 0.5).Wait).To(BeNumerically("~", MM1(1, 2).Wait/2, 1e-9))
+	})
+
+	It("have an infinite wait when the server is saturated", func() {
+		Expect(math.IsInf(MM1(2, 2).Wait, 1)).To(BeTrue())
+	})
+
+	It("flag the metrics beyond tolerance", func() {
+		expected := MM1(1, 2)
+		Expect(Check(Metrics{0.51, 0.52, 0.49}, expected, 0.05)).To(Succeed())
+		err := Check(Metrics{0.51, 0.6, 0.49}, expected, 0.05)
+		Expect(err).To(MatchError(ContainSubstring("wait is 0.6000, expected 0.5000")))
+		Expect(err.Error()).NotTo(ContainSubstring("utilization"))
+	})
+})
@@ -4,8 +4,8 @@ go 1.16
 
 require (
 	github.com/go-echarts/go-echarts/v2 v2.2.4
-	github.com/montanaflynn/stats v0.6.6 // indirect
-	github.com/onsi/ginkgo v1.16.4 // indirect
-	github.com/onsi/ginkgo/v2 v2.1.3 // indirect
-	github.com/onsi/gomega v1.19.0 // indirect
+	github.com/montanaflynn/stats v0.6.6
+	github.com/onsi/ginkgo v1.16.4
+	github.com/onsi/ginkgo/v2 v2.1.3
+	github.com/onsi/gomega v1.19.0
 )
@@ -0,0 +1,71 @@
+package sim
+
+// Activity is something that takes simulation time to complete, like a server serving
+// a request, and that can be interrupted before it completes, e.g. because the server
+// crashed or a request with higher priority preempted it.
+type Activity struct {
+	s           *Simulation
+	completion  *Event
+	onInterrupt Callback
+	payload     interface{}
+	start       float64
+}
+
+// Interruption is the payload passed to the interrupt callback of an activity
+type Interruption struct {
+	// Reason why the activity was interrupted
+	Reason error
+	// Payload of the activity
+	Payload interface{}
+	// Started is the time the activity started
+	Started float64
+	// Remaining is how much time the activity needed to complete
+	Remaining float64
+}
+
+// StartActivity starts an activity that lasts duration. When the activity completes,
+// onComplete is called with payload. If the activity is interrupted instead, onComplete
+// is never called and onInterrupt, if not nil, is called with an Interruption.
+func (s *Simulation) StartActivity(
+	duration float64, onComplete Callback, payload interface{}, onInterrupt Callback) *Activity {
+
+	a := &Activity{
+		s:           s,
+		onInterrupt: onInterrupt,
+		payload:     payload,
+		start:       s.Now(),
+	}
+	a.completion = s.Schedule(Event{
+		Time:        s.Now() + duration,
+		CallbackFun: onComplete,
+		Payload:     payload,
+	})
+	return a
+}
+
+// Active returns true if the activity neither completed nor was interrupted
+func (t *Activity) Active() bool {
+	return t.completion.status == queued
+}
+
+// Interrupt cancels the completion of the activity and schedules at the current time the
+// interrupt callback with the reason of the interruption. It returns false, and does
+// nothing, if the activity is not active.
+func (t *Activity) Interrupt(reason error) bool {
+	if !t.s.Cancel(t.completion) {
+		return false
+	}
+	if t.onInterrupt != nil {
+		t.s.Schedule(Event{
+			Time:        t.s.Now(),
+			CallbackFun: t.onInterrupt,
+			Payload: &Interruption{
+				Reason:    reason,
+				Payload:   t.payload,
+				Started:   t.start,
+				Remaining: t.completion.Time - t.s.Now(),
+			},
+		})
+	}
+	return true
+}
@@ -0,0 +1,293 @@
+package sim
+
+import (
+	"time"
+)
+
+// Clock is the part of the time package used by code that tells the time or waits.
+// Code written against Clock runs in production with WallClock and deterministically in
+// a simulation with a VirtualClock.
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+	// Sleep pauses the caller for at least the duration d
+	Sleep(d time.Duration)
+	// After waits for the duration to elapse and then sends the current time on the
+	// returned channel
+	After(d time.Duration) <-chan time.Time
+	// NewTimer creates a Timer that sends the current time on its channel after at least
+	// the duration d
+	NewTimer(d time.Duration) Timer
+	// AfterFunc waits for the duration to elapse and then calls f in its own goroutine
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is the Clock counterpart of time.Timer
+type Timer interface {
+	// C returns the channel on which the time is delivered
+	C() <-chan time.Time
+	// Stop prevents the Timer from firing. It returns false if the timer already
+	// expired or was stopped
+	Stop() bool
+	// Reset changes the timer to expire after the duration d. It returns true if the
+	// timer had been active
+	Reset(d time.Duration) bool
+}
+
+// WallClock is the Clock backed by the time package
+type WallClock struct{}
+
+func (WallClock) Now() time.Time                         { return time.Now() }
+func (WallClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (WallClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+func (WallClock) NewTimer(d time.Duration) Timer {
+	return wallTimer{time.NewTimer(d)}
+}
+
+func (WallClock) AfterFunc(d time.Duration, f func()) Timer {
+	return wallTimer{time.AfterFunc(d, f)}
+}
+
+type wallTimer struct {
+	*time.Timer
+}
+
+func (t wallTimer) C() <-chan time.Time { return t.Timer.C }
+
+// VirtualClock is a Clock driven by the event loop of a simulation: one unit of
+// simulation time is one second, and timers fire when the simulation dispatches their
+// events.
+//
+// Code that blocks on the clock must run in a process, a goroutine started with Go or
+// AfterFunc. The event loop runs processes in lockstep with the callbacks: after
+// dispatching an event it resumes, one at a time and in a deterministic order, the
+// processes the event woke up, and waits for each of them to block again before
+// dispatching the next event. A process blocks on the clock when it calls Sleep or
+// Await, and when it calls C on a pending timer, e.g. through After: the process is then
+// expected to receive from the channel right away, e.g. with a select, and is resumed
+// when the timer fires. A select must wait for at most one timer, and a process must not
+// block in any other way. Arming a timer with NewTimer or Reset does not block: the
+// process can keep using the clock, e.g. Sleep, until it waits for the timer.
+//
+// Callbacks can use Now, After, NewTimer and AfterFunc, but not Sleep or Await.
+type VirtualClock struct {
+	s     *Simulation
+	epoch time.Time
+	// ready are the processes to resume once the event being dispatched completes
+	ready []wakeup
+	// yield is signalled by the running process when it blocks or returns
+	yield chan struct{}
+	// current is the running process, nil while the event loop runs
+	current *process
+}
+
+// process is a goroutine run in lockstep with the event loop
+type process struct {
+	resume chan struct{}
+	// awaiting is the timer the process is blocked on, if any
+	awaiting *virtualTimer
+}
+
+// wakeup hands the execution to a process
+type wakeup struct {
+	p       *process
+	deliver func()
+}
+
+// NewVirtualClock returns a clock driven by s, whose time zero corresponds to epoch.
+// A simulation can have at most one virtual clock.
+func NewVirtualClock(s *Simulation, epoch time.Time) *VirtualClock {
+	c := &VirtualClock{
+		s:     s,
+		epoch: epoch,
+		yield: make(chan struct{}),
+	}
+	s.clock = c
+	return c
+}
+
+// Now returns the epoch plus the current simulation time
+func (c *VirtualClock) Now() time.Time {
+	return c.time(c.s.Now())
+}
+
+func (c *VirtualClock) time(t float64) time.Time {
+	return c.epoch.Add(time.Duration(t * float64(time.Second)))
+}
+
+// Go starts f in a new process, at the current simulation time
+func (c *VirtualClock) Go(f func()) {
+	c.s.Schedule(Event{
+		Time: c.s.Now(),
+		CallbackFun: func(t float64, payload interface{}) []Event {
+			c.start(f)
+			return nil
+		},
+	})
+}
+
+func (c *VirtualClock) start(f func()) {
+	p := &process{resume: make(chan struct{})}
+	c.ready = append(c.ready, wakeup{p: p, deliver: func() {
+		go func() {
+			defer func() {
+				c.yield <- struct{}{}
+			}()
+			f()
+		}()
+	}})
+}
+
+// Sleep blocks the calling process for the duration d of simulation time. Like
+// time.Sleep, a negative duration is a zero one.
+func (c *VirtualClock) Sleep(d time.Duration) {
+	p := c.mustBeProcess("Sleep")
+	c.s.Schedule(Event{
+		Time: c.s.Now() + nonNegative(d).Seconds(),
+		CallbackFun: func(t float64, payload interface{}) []Event {
+			c.wake(p)
+			return nil
+		},
+	})
+	c.park(p)
+}
+
+// Await blocks the calling process until it is resumed. start is called before
+// blocking with the function that resumes the process: it typically schedules events
+// whose callbacks call resume, e.g. once the response of a request sent by the process
+// is available. resume must be called at most once, from a callback.
+func (c *VirtualClock) Await(start func(resume func())) {
+	p := c.mustBeProcess("Await")
+	start(func() {
+		c.wake(p)
+	})
+	c.park(p)
+}
+
+// After is equivalent to NewTimer(d).C()
+func (c *VirtualClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+// NewTimer returns a timer that fires after the duration d of simulation time
+func (c *VirtualClock) NewTimer(d time.Duration) Timer {
+	t := &virtualTimer{c: c, ch: make(chan time.Time, 1)}
+	t.arm(d)
+	return t
+}
+
+// AfterFunc starts f in a new process after the duration d of simulation time
+func (c *VirtualClock) AfterFunc(d time.Duration, f func()) Timer {
+	t := &virtualTimer{c: c, f: f}
+	t.arm(d)
+	return t
+}
+
+func (c *VirtualClock) mustBeProcess(method string) *process {
+	if c.current == nil {
+		panic("sim: VirtualClock." + method + " called outside of a process")
+	}
+	return c.current
+}
+
+func (c *VirtualClock) wake(p *process) {
+	c.ready = append(c.ready, wakeup{p: p, deliver: func() {
+		p.resume <- struct{}{}
+	}})
+}
+
+// park blocks the running process p until it is resumed
+func (c *VirtualClock) park(p *process) {
+	c.yield <- struct{}{}
+	<-p.resume
+}
+
+// settle runs the processes woken up by the last event until all of them are blocked
+func (c *VirtualClock) settle() {
+	for len(c.ready) > 0 {
+		w := c.ready[0]
+		c.ready = c.ready[1:]
+		c.current = w.p
+		w.deliver()
+		<-c.yield
+		c.current = nil
+	}
+}
+
+type virtualTimer struct {
+	c  *VirtualClock
+	ch chan time.Time
+	// f is the function started by AfterFunc timers
+	f func()
+	// owner is the last process that waited for the timer, if any
+	owner *process
+	ev    *Event
+}
+
+// C returns the channel of the timer. A process calling C on a timer that did not fire
+// yet hands the execution back to the event loop, and is resumed when the timer fires.
+func (t *virtualTimer) C() <-chan time.Time {
+	if p := t.c.current; p != nil && t.f == nil && t.ev != nil && len(t.ch) == 0 {
+		t.owner = p
+		p.awaiting = t
+		t.c.yield <- struct{}{}
+	}
+	return t.ch
+}
+
+func (t *virtualTimer) Stop() bool {
+	if t.ev == nil {
+		return false
+	}
+	stopped := t.c.s.Cancel(t.ev)
+	t.ev = nil
+	return stopped
+}
+
+func (t *virtualTimer) Reset(d time.Duration) bool {
+	active := t.Stop()
+	t.arm(d)
+	return active
+}
+
+// arm schedules the timer to fire after d, right away if d is negative
+func (t *virtualTimer) arm(d time.Duration) {
+	t.ev = t.c.s.Schedule(Event{
+		Time:        t.c.s.Now() + nonNegative(d).Seconds(),
+		CallbackFun: t.fire,
+	})
+}
+
+// nonNegative returns d, or zero if d is negative: a duration that already expired, e.g.
+// deadline.Sub(Now()), must not schedule an event in the past
+func nonNegative(d time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+func (t *virtualTimer) fire(now float64, payload interface{}) []Event {
+	t.ev = nil
+	if t.f != nil {
+		t.c.start(t.f)
+		return nil
+	}
+
+	v := t.c.time(now)
+	deliver := func() {
+		select {
+		case t.ch <- v:
+		default:
+			// like time.Timer, drop the value if the previous one was not received
+		}
+	}
+	if p := t.owner; p != nil && p.awaiting == t {
+		p.awaiting = nil
+		t.c.ready = append(t.c.ready, wakeup{p: p, deliver: deliver})
+		return nil
+	}
+	deliver()
+	return nil
+}
@@ -0,0 +1,127 @@
+package sim
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Component is a named part of a model, like a client, a server or the queue of a
+// server. Components form a hierarchy through their parent, and are identified in a
+// simulation by their path, e.g. cluster/server-2/queue.
+type Component interface {
+	// Name of the component, unique among the components with the same parent
+	Name() string
+	// Parent is the component this one is part of, nil for the top level components
+	Parent() Component
+	// Start is called when the component is added to a simulation, and returns the
+	// events that seed the component
+	Start(s *Simulation) []Event
+	// Stats returns the statistics gathered by the component, by name
+	Stats() map[string]float64
+}
+
+// Base implements the Name and Parent methods of Component. It is meant to be embedded
+// in components.
+type Base struct {
+	name   string
+	parent Component
+}
+
+// NewBase returns a Base for a component with the given name and parent
+func NewBase(name string, parent Component) Base {
+	return Base{name: name, parent: parent}
+}
+
+func (t Base) Name() string      { return t.name }
+func (t Base) Parent() Component { return t.parent }
+
+// Path returns the path of a component: the names of its ancestors and of the component
+// itself, separated by slashes
+func Path(c Component) string {
+	if c.Parent() == nil {
+		return c.Name()
+	}
+	return Path(c.Parent()) + "/" + c.Name()
+}
+
+// Add registers a component with the simulation and queues the events returned by its
+// Start method. Components implementing State or Cloner are also registered, with their
+// path as name, with RegisterState and RegisterCloner. Add fails if a component with the
+// same path was already added.
+func (s *Simulation) Add(c Component) error {
+	path := Path(c)
+	if _, ok := s.components[path]; ok {
+		return fmt.Errorf("sim: component %q already added", path)
+	}
+	s.components[path] = c
+	s.componentPaths = append(s.componentPaths, path)
+	if st, ok := c.(State); ok {
+		s.RegisterState(path, st)
+	}
+	if cl, ok := c.(Cloner); ok {
+		s.RegisterCloner(path, cl)
+	}
+
+	for _, e := range c.Start(s) {
+		s.Schedule(e)
+	}
+	return nil
+}
+
+// Components returns the components of the simulation, in the order they were added
+func (s *Simulation) Components() []Component {
+	components := make([]Component, 0, len(s.componentPaths))
+	for _, path := range s.componentPaths {
+		components = append(components, s.components[path])
+	}
+	return components
+}
+
+// Component returns the component with the given path, nil if there is none
+func (s *Simulation) Component(path string) Component {
+	return s.components[path]
+}
+
+// ComponentStats collects the stats of all the components, by component path
+func (s *Simulation) ComponentStats() map[string]map[string]float64 {
+	stats := make(map[string]map[string]float64, len(s.components))
+	for path, c := range s.components {
+		stats[path] = c.Stats()
+	}
+	return stats
+}
+
+// Trace routes the trace output of the components whose path is prefix, or starts with
+// prefix followed by a slash, to w. The empty prefix matches all the components.
+func (s *Simulation) Trace(prefix string, w io.Writer) {
+	s.tracers = append(s.tracers, tracer{prefix: prefix, w: w})
+}
+
+// Tracef writes a trace line for component c, prefixed by the simulation time and the
+// path of the component, to the writers whose prefix matches the path of c
+func (s *Simulation) Tracef(c Component, format string, args ...interface{}) {
+	if len(s.tracers) == 0 {
+		return
+	}
+	path := Path(c)
+	var line string
+	for _, t := range s.tracers {
+		if !t.matches(path) {
+			continue
+		}
+		if line == "" {
+			line = fmt.Sprintf("t=%.3f %s: %s\n", s.now, path, fmt.Sprintf(format, args...))
+		}
+		_, _ = io.WriteString(t.w, line)
+	}
+}
+
+type tracer struct {
+	prefix string
+	w      io.Writer
+}
+
+func (t tracer) matches(path string) bool {
+	return t.prefix == "" || path == t.prefix || strings.HasPrefix(path, t.prefix+"/")
+}
@@ -1,5 +1,11 @@
 package sim
 
+import (
+	"reflect"
+	"runtime"
+	"strings"
+)
+
 // Event in the simulation. An event is a timestamped structured which contains that wraps
 // the logic that needs to run when the event triggers
 type Event struct {
@@ -9,9 +15,46 @@ type Event struct {
 	CallbackFun Callback
 	// Payload to pass to the CallbackFun
 	Payload interface{}
+	// Handler is the name of a callback registered with Simulation.Register. It is used
+	// when CallbackFun is nil. Unlike function values, names can be saved in a snapshot,
+	// so only events with a Handler can be part of Simulation.Snapshot
+	Handler string
+
+	status eventStatus
 }
 
+// eventStatus tracks an event from the moment it is queued, so that it can be cancelled
+type eventStatus int
+
+const (
+	unscheduled eventStatus = iota
+	queued
+	dispatched
+	cancelled
+)
+
 // Callback is a function associated to an event. It receives as input the time of the
 // event that triggered the callback and the payload of the event. Returns zero or more
 // events that are triggered by the callback function
 type Callback func(t float64, payload interface{}) []Event
+
+// callbackName returns the Handler of the event or, for function values, the name of the
+// function without its import path, e.g. main.(*server).processRequest
+func callbackName(e *Event) string {
+	if e.CallbackFun == nil {
+		return e.Handler
+	}
+	return funcName(reflect.ValueOf(e.CallbackFun).Pointer())
+}
+
+func funcName(pc uintptr) string {
+	f := runtime.FuncForPC(pc)
+	if f == nil {
+		return "unknown"
+	}
+	name := f.Name()
+	// drop the import path up to its last element
+	name = name[strings.LastIndex(name, "/")+1:]
+	// method values are suffixed with -fm
+	return strings.TrimSuffix(name, "-fm")
+}
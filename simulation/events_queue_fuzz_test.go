@@ -0,0 +1,96 @@
+//go:build go1.18
+// +build go1.18
+
+package sim
+
+import (
+	"container/heap"
+	"context"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// FuzzEventsQueue interprets the input as a sequence of operations on an EventsQueue:
+// each operation is 3 bytes, pushing an event at the time encoded in the last two bytes
+// when the first byte is even and popping the next event otherwise. Popped events must
+// come out in non-decreasing time order, and no event must be lost.
+func FuzzEventsQueue(f *testing.F) {
+	f.Add([]byte{0, 0, 5, 0, 0, 3, 1, 0, 0, 0, 0, 3, 1, 0, 0})
+	f.Add([]byte{0, 1, 0, 0, 1, 0, 0, 0, 1, 1, 0, 0, 1, 0, 0, 1, 0, 0})
+
+	f.Fuzz(func(t *testing.T, ops []byte) {
+		q := &EventsQueue{}
+		heap.Init(q)
+		pushed, popped := 0, 0
+		last := math.Inf(-1)
+		for ; len(ops) >= 3; ops = ops[3:] {
+			if ops[0]%2 == 0 {
+				at := float64(binary.BigEndian.Uint16(ops[1:3]))
+				heap.Push(q, &Event{Time: at})
+				pushed++
+				// a push resets the order: the new event can be earlier than the last
+				// popped one
+				last = math.Inf(-1)
+				continue
+			}
+			if q.Len() == 0 {
+				continue
+			}
+			e := heap.Pop(q).(*Event)
+			popped++
+			if e.Time < last {
+				t.Fatalf("popped %v after %v", e.Time, last)
+			}
+			last = e.Time
+		}
+		for q.Len() > 0 {
+			e := heap.Pop(q).(*Event)
+			popped++
+			if e.Time < last {
+				t.Fatalf("draining, popped %v after %v", e.Time, last)
+			}
+			last = e.Time
+		}
+		if pushed != popped {
+			t.Fatalf("pushed %d events but popped %d", pushed, popped)
+		}
+	})
+}
+
+// FuzzSimulationSchedule schedules events at the times encoded in the input, cancels
+// every third one and checks that the simulation dispatches the others, and only them,
+// in non-decreasing time order.
+func FuzzSimulationSchedule(f *testing.F) {
+	f.Add([]byte{3, 1, 4, 1, 5, 9, 2, 6, 5, 3, 5})
+
+	f.Fuzz(func(t *testing.T, times []byte) {
+		s := New(math.MaxFloat64, &EventsQueue{}, nil)
+		var dispatched []float64
+		cb := func(at float64, payload interface{}) []Event {
+			if payload.(int)%3 == 0 {
+				t.Fatalf("cancelled event %d dispatched", payload)
+			}
+			dispatched = append(dispatched, at)
+			return nil
+		}
+		for i, at := range times {
+			e := s.Schedule(Event{Time: float64(at), CallbackFun: cb, Payload: i})
+			if i%3 == 0 {
+				s.Cancel(e)
+			}
+		}
+		if _, err := s.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if want := len(times) - (len(times)+2)/3; len(dispatched) != want {
+			t.Fatalf("dispatched %d events, want %d", len(dispatched), want)
+		}
+		for i := 1; i < len(dispatched); i++ {
+			if dispatched[i] < dispatched[i-1] {
+				t.Fatalf("dispatched %v after %v", dispatched[i], dispatched[i-1])
+			}
+		}
+	})
+}
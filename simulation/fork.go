@@ -0,0 +1,95 @@
+package sim
+
+import (
+	"fmt"
+)
+
+// Cloner is implemented by the components of a simulation that take part in Fork.
+// Components are registered with Simulation.RegisterCloner.
+type Cloner interface {
+	// Clone returns a deep copy of the component that belongs to the fork f. Clone must
+	// register on f, with the same names, the handlers and states the component
+	// registered on the original simulation, bound to the copy.
+	Clone(f *Simulation) Cloner
+}
+
+// RegisterCloner adds a component to the ones copied by Fork. The name identifies the
+// component in the fork, see Simulation.Cloned.
+func (s *Simulation) RegisterCloner(name string, c Cloner) {
+	if _, ok := s.cloners[name]; !ok {
+		s.clonerNames = append(s.clonerNames, name)
+	}
+	s.cloners[name] = c
+}
+
+// Cloned returns the copy of the component registered with name, or nil if no such
+// component exists. It is meant to be called on a fork to get hold of the components
+// whose parameters change in the what-if branch, or from Clone to get hold of the copies
+// of the components registered before.
+func (s *Simulation) Cloned(name string) Cloner {
+	return s.cloners[name]
+}
+
+// Fork returns a deep copy of the simulation at the current time, so that several
+// futures can be run from an identical state. The fork has the same clock, stats,
+// budget, causality policy, pending events and random number generator state as s and a
+// copy of each component registered with RegisterCloner; running the fork does not
+// affect s and vice versa. The components are cloned in the order they were registered,
+// and the components of s that are cloners are components of the fork too. The fork
+// writes its trace output to the same writers as s.
+//
+// Pending events must refer to their callback by Handler. The handlers that do not
+// belong to a cloner are copied as they are, so they should not point to mutable state,
+// and neither should the payloads, which are copied as they are too. The fork runs at
+// the same pace as s but it is not paused. The time over callback is not copied: set the
+// one of the fork with SetTimeOverCallback.
+func (s *Simulation) Fork() (*Simulation, error) {
+	f := New(s.MaxTime, &EventsQueue{}, nil)
+	f.Budget = s.Budget
+	f.Causality = s.Causality
+	f.now = s.now
+	f.stats = s.stats
+	f.src.state = s.src.state
+	f.pacer = newPacer(s.pacer.currentSpeed())
+	f.tracers = append(f.tracers, s.tracers...)
+	// the handlers registered directly with Register are shared, the cloners then
+	// replace theirs with the ones bound to the copies
+	for name, cb := range s.handlers {
+		f.handlers[name] = cb
+	}
+	for _, name := range s.clonerNames {
+		f.RegisterCloner(name, s.cloners[name].Clone(f))
+	}
+	// the components that are cloned keep their place in the hierarchy of the fork
+	for _, path := range s.componentPaths {
+		if c, ok := f.cloners[path].(Component); ok {
+			f.components[path] = c
+			f.componentPaths = append(f.componentPaths, path)
+		}
+	}
+
+	// the heap layout is kept, so that the fork dispatches events with the same time in
+	// the same order as the original
+	for _, e := range *s.q {
+		if e.status == cancelled {
+			*f.q = append(*f.q, &Event{Time: e.Time, status: cancelled})
+			continue
+		}
+		if e.CallbackFun != nil || e.Handler == "" {
+			return nil, fmt.Errorf("sim: event at time %v has no handler name", e.Time)
+		}
+		if _, ok := f.handlers[e.Handler]; !ok {
+			return nil, fmt.Errorf("sim: no handler registered with name %q", e.Handler)
+		}
+		evCopy := *e
+		*f.q = append(*f.q, &evCopy)
+	}
+
+	return f, nil
+}
+
+// SetTimeOverCallback replaces the callback called once the simulation maxTime is
+// reached
+func (s *Simulation) SetTimeOverCallback(timeOverCallback OnTimeOver) {
+	s.onTimeOver = timeOverCallback
+}
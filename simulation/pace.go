@@ -0,0 +1,190 @@
+package sim
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Pace makes Run dispatch the events paced against the wall clock instead of jumping
+// from one event to the next: an event happening d units of simulation time after the
+// previous one is dispatched d/speed seconds of wall clock after it. A speed of 1 runs
+// the simulation in real time, 10 ten times faster and 0.1 ten times slower. A speed
+// of zero or less disables pacing.
+//
+// Pacing does not change the order in which events are dispatched. Pace, Pause and
+// Resume can be called from any goroutine, also while Run is in progress.
+func (s *Simulation) Pace(speed float64) {
+	s.pacer.setSpeed(speed)
+}
+
+// Pause stops the dispatching of events until Resume is called. A Run in progress
+// blocks, after completing the event being dispatched, until the simulation is resumed
+// or its context is done.
+func (s *Simulation) Pause() {
+	s.pacer.pause()
+}
+
+// Resume restarts the dispatching of events stopped by Pause. A paced simulation
+// continues from the simulation time it was paused at.
+func (s *Simulation) Resume() {
+	s.pacer.resume()
+}
+
+// pacer maps simulation time to wall clock time. The simulation time originSim is
+// mapped to the wall clock time origin and the mapping is re-anchored every time the
+// speed changes or the simulation is paused and resumed, so that simulation time never
+// jumps.
+type pacer struct {
+	mu        sync.Mutex
+	speed     float64
+	paused    bool
+	origin    time.Time
+	originSim float64
+	// wake is signalled when the speed changes or the simulation is paused or resumed,
+	// to interrupt the wait of a Run in progress
+	wake chan struct{}
+	// pacing is 1 while the speed is positive or the simulation is paused, so that Run
+	// can skip wait without taking the lock otherwise
+	pacing int32
+}
+
+func newPacer(speed float64) *pacer {
+	return &pacer{speed: speed, wake: make(chan struct{}, 1)}
+}
+
+// start anchors the simulation time of a starting run to the current wall clock time
+func (p *pacer) start(simTime float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.origin = time.Now()
+	p.originSim = simTime
+}
+
+func (p *pacer) setSpeed(speed float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.speed > 0 && !p.paused {
+		now := time.Now()
+		p.originSim = p.simTime(now)
+		p.origin = now
+	}
+	p.speed = speed
+	p.updatePacing()
+	p.notify()
+}
+
+func (p *pacer) currentSpeed() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.speed
+}
+
+func (p *pacer) pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.paused {
+		return
+	}
+	if p.speed > 0 {
+		p.originSim = p.simTime(time.Now())
+	}
+	p.paused = true
+	p.updatePacing()
+	p.notify()
+}
+
+func (p *pacer) resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.paused {
+		return
+	}
+	p.origin = time.Now()
+	p.paused = false
+	p.updatePacing()
+	p.notify()
+}
+
+// updatePacing updates pacing after a change of speed or pause. It must be called with
+// p.mu held
+func (p *pacer) updatePacing() {
+	var pacing int32
+	if p.speed > 0 || p.paused {
+		pacing = 1
+	}
+	atomic.StoreInt32(&p.pacing, pacing)
+}
+
+// active returns true if wait can block: the simulation is paced or paused
+func (p *pacer) active() bool {
+	return atomic.LoadInt32(&p.pacing) == 1
+}
+
+// simTime returns the simulation time corresponding to the wall clock time now. It must
+// be called with p.mu held and a positive speed
+func (p *pacer) simTime(now time.Time) float64 {
+	return p.originSim + now.Sub(p.origin).Seconds()*p.speed
+}
+
+func (p *pacer) notify() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// wait blocks until the event at simulation time t is due, ctx is done or the
+// deadline, if not zero, is reached
+func (p *pacer) wait(ctx context.Context, t float64, deadline time.Time) error {
+	for {
+		p.mu.Lock()
+		paused := p.paused
+		var d time.Duration
+		if !paused {
+			if p.speed <= 0 {
+				p.mu.Unlock()
+				return nil
+			}
+			target := p.origin.Add(
+				time.Duration((t - p.originSim) / p.speed * float64(time.Second)))
+			d = time.Until(target)
+		}
+		p.mu.Unlock()
+
+		if !paused && d <= 0 {
+			return nil
+		}
+		if !deadline.IsZero() {
+			untilDeadline := time.Until(deadline)
+			if untilDeadline <= 0 {
+				return nil
+			}
+			if paused || untilDeadline < d {
+				paused, d = false, untilDeadline
+			}
+		}
+
+		// a nil channel blocks forever: while paused only ctx and wake end the wait
+		var timeout <-chan time.Time
+		var timer *time.Timer
+		if !paused {
+			timer = time.NewTimer(d)
+			timeout = timer.C
+		}
+		var err error
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-p.wake:
+		case <-timeout:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package sim
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Parallel is a parallel discrete event simulation. The model is partitioned in logical
+// processes, each with its own events queue, clock and random number generator, which
+// communicate only by sending each other timestamped events.
+//
+// The synchronization is conservative and relies on the lookahead: an event sent by a
+// logical process at time t must happen at t+lookahead or later, for example because
+// it models a message that takes at least lookahead to cross the network. The
+// simulation advances in windows: if T is the time of the earliest pending event, every
+// logical process can safely dispatch, in parallel with the others, its events before
+// T+lookahead, because no other process can send it an event before that time. At the
+// end of each window the events sent are delivered, in an order that only depends on
+// their time and sender, so the results of a simulation depend on the seeds of its
+// logical processes but not on the number of workers: running with one worker is
+// equivalent to running the logical processes sequentially. It is also equivalent to a
+// Simulation whose components draw from generators seeded like their logical processes,
+// unless two events of a logical process happen at the same time.
+type Parallel struct {
+	// MaxTime is how long the simulation runs, in simulation time
+	MaxTime float64
+	// Lookahead is the minimum delay of the events sent between logical processes
+	Lookahead float64
+	// Workers is the number of goroutines dispatching events. Zero or less means one
+	// worker per logical process.
+	Workers int
+
+	lps        []*LogicalProcess
+	onTimeOver OnTimeOver
+	stats      Stats
+}
+
+// LogicalProcess is a partition of a Parallel simulation
+type LogicalProcess struct {
+	id     int
+	p      *Parallel
+	q      EventsQueue
+	now    float64
+	rnd    *rand.Rand
+	outbox []message
+	events int
+	err    error
+}
+
+// message is an event sent between logical processes
+type message struct {
+	from, to int
+	// seq orders the messages sent by the same process
+	seq int
+	e   Event
+}
+
+// NewParallel returns a parallel simulation. The parameters maxTime and
+// timeOverCallback have the same meaning as in Run, but the time over callback is
+// called between two windows, once the earliest pending event is after maxTime. The
+// lookahead must be positive.
+func NewParallel(maxTime, lookahead float64, timeOverCallback OnTimeOver) *Parallel {
+	if lookahead <= 0 {
+		panic(fmt.Sprintf("sim: lookahead must be positive, got %v", lookahead))
+	}
+	return &Parallel{
+		MaxTime:    maxTime,
+		Lookahead:  lookahead,
+		onTimeOver: timeOverCallback,
+	}
+}
+
+// NewProcess adds a logical process to the simulation, whose random number generator
+// is seeded with seed
+func (p *Parallel) NewProcess(seed int64) *LogicalProcess {
+	lp := &LogicalProcess{
+		id:  len(p.lps),
+		p:   p,
+		rnd: rand.New(NewSource(seed)),
+	}
+	p.lps = append(p.lps, lp)
+	return lp
+}
+
+// ID returns the index of the logical process in the simulation
+func (t *LogicalProcess) ID() int {
+	return t.id
+}
+
+// Now returns the time of the event being dispatched by the logical process, or of the
+// last one it dispatched
+func (t *LogicalProcess) Now() float64 {
+	return t.now
+}
+
+// Rand returns the random number generator of the logical process. The components of a
+// logical process must only use this generator to keep the simulation deterministic.
+func (t *LogicalProcess) Rand() *rand.Rand {
+	return t.rnd
+}
+
+// Schedule adds an event to the queue of the logical process. Callbacks schedule local
+// events by returning them.
+func (t *LogicalProcess) Schedule(e Event) {
+	heap.Push(&t.q, &e)
+}
+
+// Send schedules the event e on the logical process to. The event must happen at least
+// lookahead after the current time of the sender, otherwise the simulation fails.
+func (t *LogicalProcess) Send(to *LogicalProcess, e Event) {
+	if e.Time < t.now+t.p.Lookahead && t.err == nil {
+		t.err = fmt.Errorf(
+			"sim: process %d sent an event at %v to process %d before the lookahead %v",
+			t.id, e.Time, to.id, t.now+t.p.Lookahead)
+	}
+	t.outbox = append(t.outbox, message{from: t.id, to: to.id, seq: len(t.outbox), e: e})
+}
+
+// dispatch runs the events of the logical process before until
+func (t *LogicalProcess) dispatch(until float64) {
+	for t.q.Len() > 0 && t.q[0].Time < until && t.err == nil {
+		e := heap.Pop(&t.q).(*Event)
+		t.now = e.Time
+		if e.CallbackFun == nil {
+			t.err = fmt.Errorf("sim: event at %v of process %d has no callback", e.Time, t.id)
+			return
+		}
+		for _, ev := range e.CallbackFun(t.now, e.Payload) {
+			if ev.Time < t.now {
+				t.err = fmt.Errorf("%w: %s of process %d scheduled an event at %v, "+
+					"before the current time %v", ErrPastEvent, callbackName(e), t.id, ev.Time, t.now)
+				return
+			}
+			evCopy := ev
+			heap.Push(&t.q, &evCopy)
+		}
+		t.events++
+	}
+}
+
+// Run the simulation until no events are left or ctx is done, in which case it returns
+// ctx.Err(). The context is checked between windows.
+func (p *Parallel) Run(ctx context.Context) (Stats, error) {
+	start := time.Now()
+	elapsed := p.stats.Elapsed
+	for _, lp := range p.lps {
+		heap.Init(&lp.q)
+	}
+
+	for {
+		p.deliver()
+		p.stats.Events = 0
+		for _, lp := range p.lps {
+			p.stats.Events += lp.events
+			if lp.now > p.stats.Time {
+				p.stats.Time = lp.now
+			}
+		}
+		p.stats.Elapsed = elapsed + time.Since(start)
+
+		for _, lp := range p.lps {
+			if lp.err != nil {
+				return p.stats, lp.err
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return p.stats, err
+		}
+
+		next := math.Inf(1)
+		for _, lp := range p.lps {
+			if lp.q.Len() > 0 && lp.q[0].Time < next {
+				next = lp.q[0].Time
+			}
+		}
+		if math.IsInf(next, 1) {
+			p.stats.Completed = true
+			return p.stats, nil
+		}
+		if next > p.MaxTime && p.onTimeOver != nil {
+			p.onTimeOver()
+		}
+
+		p.window(next + p.Lookahead)
+	}
+}
+
+// window makes every logical process dispatch its events before until
+func (p *Parallel) window(until float64) {
+	workers := p.Workers
+	if workers <= 0 || workers > len(p.lps) {
+		workers = len(p.lps)
+	}
+	if workers == 1 {
+		for _, lp := range p.lps {
+			lp.dispatch(until)
+		}
+		return
+	}
+
+	lps := make(chan *LogicalProcess, len(p.lps))
+	for _, lp := range p.lps {
+		lps <- lp
+	}
+	close(lps)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for lp := range lps {
+				lp.dispatch(until)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// deliver moves the events sent during the last window to the queues of their
+// recipients, in time order and, for the same time, by sender
+func (p *Parallel) deliver() {
+	var messages []message
+	for _, lp := range p.lps {
+		messages = append(messages, lp.outbox...)
+		lp.outbox = lp.outbox[:0]
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		a, b := messages[i], messages[j]
+		if a.e.Time != b.e.Time {
+			return a.e.Time < b.e.Time
+		}
+		if a.from != b.from {
+			return a.from < b.from
+		}
+		return a.seq < b.seq
+	})
+	for _, m := range messages {
+		p.lps[m.to].Schedule(m.e)
+	}
+}
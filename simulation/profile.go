@@ -0,0 +1,115 @@
+package sim
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// Profiler collects, for each callback, the number of events dispatched and the wall
+// clock time spent running it, together with the maximum size reached by the events
+// queue. It helps finding the components that dominate the cost of a simulation.
+//
+// Callbacks are identified by their Handler name or, for function values, by the name
+// of the function: callbacks that are methods are reported with their receiver type,
+// e.g. main.(*server).processRequest, so the table also tells which component they
+// belong to.
+type Profiler struct {
+	// Out, if not nil, is where the profile table is written at the end of each run
+	Out io.Writer
+
+	events        int
+	queueHighMark int
+	byCallback    map[string]*callbackProfile
+	names         map[uintptr]string
+}
+
+type callbackProfile struct {
+	name   string
+	events int
+	total  time.Duration
+}
+
+// NewProfiler returns a profiler that writes its table to out, which can be nil
+func NewProfiler(out io.Writer) *Profiler {
+	return &Profiler{
+		Out:        out,
+		byCallback: make(map[string]*callbackProfile),
+		names:      make(map[uintptr]string),
+	}
+}
+
+// Profile attaches a profiler to the simulation. The same profiler can be attached to
+// several simulations to aggregate their profiles, e.g. in a parameter sweep.
+func (s *Simulation) Profile(p *Profiler) {
+	s.profiler = p
+}
+
+func (p *Profiler) record(e *Event, d time.Duration, queueLen int) {
+	name := e.Handler
+	if e.CallbackFun != nil {
+		pc := reflect.ValueOf(e.CallbackFun).Pointer()
+		var ok bool
+		if name, ok = p.names[pc]; !ok {
+			name = funcName(pc)
+			p.names[pc] = name
+		}
+	}
+	cp, ok := p.byCallback[name]
+	if !ok {
+		cp = &callbackProfile{name: name}
+		p.byCallback[name] = cp
+	}
+	cp.events++
+	cp.total += d
+	p.events++
+	if queueLen > p.queueHighMark {
+		p.queueHighMark = queueLen
+	}
+}
+
+// WriteTo writes the profile table to w, callbacks sorted by decreasing total time
+func (p *Profiler) WriteTo(w io.Writer) (int64, error) {
+	var total time.Duration
+	profiles := make([]*callbackProfile, 0, len(p.byCallback))
+	for _, cp := range p.byCallback {
+		profiles = append(profiles, cp)
+		total += cp.total
+	}
+	sort.Slice(profiles, func(i, j int) bool {
+		if profiles[i].total != profiles[j].total {
+			return profiles[i].total > profiles[j].total
+		}
+		return profiles[i].name < profiles[j].name
+	})
+
+	cw := &countingWriter{w: w}
+	fmt.Fprintf(cw, "events: %d, queue high-water mark: %d, callbacks time: %v\n",
+		p.events, p.queueHighMark, total)
+	tw := tabwriter.NewWriter(cw, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "callback\tevents\ttotal\tmean\tshare\t")
+	for _, cp := range profiles {
+		share := 0.0
+		if total > 0 {
+			share = float64(cp.total) / float64(total) * 100
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%v\t%v\t%.1f%%\t\n",
+			cp.name, cp.events, cp.total, cp.total/time.Duration(cp.events), share)
+	}
+	err := tw.Flush()
+	return cw.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (t *countingWriter) Write(b []byte) (int, error) {
+	n, err := t.w.Write(b)
+	t.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,140 @@
+package sim
+
+import (
+	"context"
+	"errors"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"math/rand"
+	"strings"
+	"testing/quick"
+)
+
+// Properties that any simulation run by the engine must satisfy, checked against random
+// programs: sets of callbacks that schedule, return and cancel events at random.
+var _ = Describe("Engine properties", func() {
+	check := func(property func(seed int64) bool) {
+		Expect(quick.Check(property, &quick.Config{MaxCount: 200})).To(Succeed())
+	}
+
+	It("dispatches events in non-decreasing time order", func() {
+		check(func(seed int64) bool {
+			trace := runRandomProgram(seed)
+			for i := 1; i < len(trace.dispatched); i++ {
+				if trace.dispatched[i].time < trace.dispatched[i-1].time {
+					return false
+				}
+			}
+			return true
+		})
+	})
+
+	It("never dispatches cancelled events", func() {
+		check(func(seed int64) bool {
+			trace := runRandomProgram(seed)
+			for _, d := range trace.dispatched {
+				if trace.cancelled[d.id] {
+					return false
+				}
+			}
+			return true
+		})
+	})
+
+	It("dispatches the same events in the same order for the same seed", func() {
+		check(func(seed int64) bool {
+			first, second := runRandomProgram(seed), runRandomProgram(seed)
+			if len(first.dispatched) != len(second.dispatched) {
+				return false
+			}
+			for i := range first.dispatched {
+				if first.dispatched[i] != second.dispatched[i] {
+					return false
+				}
+			}
+			return true
+		})
+	})
+
+	It("rejects events scheduled in the past, naming the callback", func() {
+		check(func(seed int64) bool {
+			r := rand.New(NewSource(seed))
+			s := New(100, &EventsQueue{{Time: 0, Handler: "drift"}}, nil)
+			last := 0.0
+			wentBack := false
+			s.Register("drift", func(t float64, payload interface{}) []Event {
+				wentBack = wentBack || t < last
+				last = t
+				// one event in ten goes back in time
+				return []Event{{Time: t + r.Float64() - 0.1, Handler: "drift"}}
+			})
+
+			_, err := s.Run(context.Background())
+			return !wentBack && errors.Is(err, ErrPastEvent) &&
+				strings.HasPrefix(err.Error(), ErrPastEvent.Error()+": drift scheduled")
+		})
+	})
+})
+
+type dispatch struct {
+	id   int
+	time float64
+}
+
+type programTrace struct {
+	dispatched []dispatch
+	cancelled  map[int]bool
+}
+
+// runRandomProgram runs a simulation whose callbacks schedule up to 1000 events, choosing
+// at random, from seed, when they happen and which pending events to cancel. Times are
+// integers so that many events happen at the same time.
+func runRandomProgram(seed int64) programTrace {
+	r := rand.New(NewSource(seed))
+	s := New(100, &EventsQueue{}, nil)
+	trace := programTrace{cancelled: make(map[int]bool)}
+	pending := make(map[int]*Event)
+	ids := 0
+
+	var cb Callback
+	newEvent := func(now float64) Event {
+		ids++
+		return Event{Time: now + float64(r.Intn(5)), CallbackFun: cb, Payload: ids}
+	}
+	cb = func(t float64, payload interface{}) []Event {
+		id := payload.(int)
+		delete(pending, id)
+		trace.dispatched = append(trace.dispatched, dispatch{id: id, time: t})
+
+		var events []Event
+		for i := r.Intn(3); i > 0 && ids < 1000; i-- {
+			events = append(events, newEvent(t))
+		}
+		if ids < 1000 && r.Intn(2) == 0 {
+			e := newEvent(t)
+			pending[e.Payload.(int)] = s.Schedule(e)
+		}
+		if r.Intn(4) == 0 {
+			// cancel the pending event with the lowest id, if any
+			lowest := -1
+			for pid := range pending {
+				if lowest == -1 || pid < lowest {
+					lowest = pid
+				}
+			}
+			if lowest != -1 && s.Cancel(pending[lowest]) {
+				trace.cancelled[lowest] = true
+				delete(pending, lowest)
+			}
+		}
+		return events
+	}
+
+	for i := 0; i < 3; i++ {
+		e := newEvent(0)
+		pending[e.Payload.(int)] = s.Schedule(e)
+	}
+	_, err := s.Run(context.Background())
+	Expect(err).NotTo(HaveOccurred())
+	return trace
+}
@@ -0,0 +1,32 @@
+package sim
+
+// Source is a pseudo-random source whose whole state is a single integer, so that it can
+// be saved in a snapshot and restored later. It implements rand.Source64 with the
+// splitmix64 algorithm.
+type Source struct {
+	state uint64
+}
+
+// NewSource returns a Source seeded with seed
+func NewSource(seed int64) *Source {
+	return &Source{state: uint64(seed)}
+}
+
+// Seed sets the state of the source
+func (t *Source) Seed(seed int64) {
+	t.state = uint64(seed)
+}
+
+// Uint64 returns a pseudo-random 64-bit value
+func (t *Source) Uint64() uint64 {
+	t.state += 0x9e3779b97f4a7c15
+	z := t.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// Int63 returns a non-negative pseudo-random 63-bit integer
+func (t *Source) Int63() int64 {
+	return int64(t.Uint64() >> 1)
+}
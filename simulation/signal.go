@@ -0,0 +1,166 @@
+package sim
+
+// Waitable is implemented by the synchronization primitives a callback can wait for:
+// Signal, Condition and Barrier. The callback is scheduled at the time the primitive is
+// satisfied, so that models do not need to poll for a state.
+type Waitable interface {
+	Wait(cb Callback)
+}
+
+// Signal schedules the callbacks waiting for it when it is fired. A broadcast signal
+// can be fired many times, each time waking the callbacks that waited since the
+// previous fire. A once signal fires at most once, and callbacks waiting on a signal
+// that already fired are scheduled right away.
+type Signal struct {
+	s       *Simulation
+	once    bool
+	fired   bool
+	payload interface{}
+	waiters []Callback
+}
+
+// NewSignal returns a broadcast signal
+func NewSignal(s *Simulation) *Signal {
+	return &Signal{s: s}
+}
+
+// NewOnceSignal returns a signal that fires at most once
+func NewOnceSignal(s *Simulation) *Signal {
+	return &Signal{s: s, once: true}
+}
+
+// Wait schedules cb at the time the signal fires, with the payload passed to Fire
+func (t *Signal) Wait(cb Callback) {
+	if t.once && t.fired {
+		t.s.Schedule(Event{Time: t.s.Now(), CallbackFun: cb, Payload: t.payload})
+		return
+	}
+	t.waiters = append(t.waiters, cb)
+}
+
+// Fire schedules at the current time the callbacks waiting for the signal, in the order
+// in which they started waiting. Firing a once signal again has no effect.
+func (t *Signal) Fire(payload interface{}) {
+	if t.once && t.fired {
+		return
+	}
+	t.fired = true
+	t.payload = payload
+	waiters := t.waiters
+	t.waiters = nil
+	for _, cb := range waiters {
+		t.s.Schedule(Event{Time: t.s.Now(), CallbackFun: cb, Payload: payload})
+	}
+}
+
+// Fired returns true if the signal fired at least once
+func (t *Signal) Fired() bool {
+	return t.fired
+}
+
+// Condition schedules the callbacks waiting for it when its predicate holds. The
+// predicate is evaluated when a callback starts waiting and every time Notify is called,
+// which the model does after changing the state the predicate depends on.
+type Condition struct {
+	s         *Simulation
+	predicate func() bool
+	waiters   []Callback
+}
+
+// NewCondition returns a condition on predicate
+func NewCondition(s *Simulation, predicate func() bool) *Condition {
+	return &Condition{s: s, predicate: predicate}
+}
+
+// Wait schedules cb at the first time the predicate holds, which is now if it already
+// holds. The payload of the callback is nil.
+func (t *Condition) Wait(cb Callback) {
+	if t.predicate() {
+		t.s.Schedule(Event{Time: t.s.Now(), CallbackFun: cb})
+		return
+	}
+	t.waiters = append(t.waiters, cb)
+}
+
+// Notify re-evaluates the predicate and, if it holds, schedules at the current time
+// the callbacks waiting for it
+func (t *Condition) Notify() {
+	if len(t.waiters) == 0 || !t.predicate() {
+		return
+	}
+	waiters := t.waiters
+	t.waiters = nil
+	for _, cb := range waiters {
+		t.s.Schedule(Event{Time: t.s.Now(), CallbackFun: cb})
+	}
+}
+
+// Barrier is satisfied once Arrive was called a given number of times, e.g. when all the
+// replies of a fan-out arrived. The payload of the callbacks is the list of the
+// payloads passed to Arrive, in arrival order.
+type Barrier struct {
+	n        int
+	payloads []interface{}
+	done     *Signal
+}
+
+// NewBarrier returns a barrier satisfied after n arrivals
+func NewBarrier(s *Simulation, n int) *Barrier {
+	b := &Barrier{n: n, done: NewOnceSignal(s)}
+	if n <= 0 {
+		b.done.Fire(nil)
+	}
+	return b
+}
+
+// Arrive records an arrival at the barrier
+func (t *Barrier) Arrive(payload interface{}) {
+	if t.done.Fired() {
+		return
+	}
+	t.payloads = append(t.payloads, payload)
+	if len(t.payloads) == t.n {
+		t.done.Fire(t.payloads)
+	}
+}
+
+// Wait schedules cb at the time the barrier is satisfied
+func (t *Barrier) Wait(cb Callback) {
+	t.done.Wait(cb)
+}
+
+// AllOf returns a once signal that fires when all of ws are satisfied. Its payload is
+// the list of the payloads of ws, in the order of ws.
+func AllOf(s *Simulation, ws ...Waitable) *Signal {
+	all := NewOnceSignal(s)
+	payloads := make([]interface{}, len(ws))
+	remaining := len(ws)
+	if remaining == 0 {
+		all.Fire(payloads)
+	}
+	for i, w := range ws {
+		i := i
+		w.Wait(func(t float64, payload interface{}) []Event {
+			payloads[i] = payload
+			remaining--
+			if remaining == 0 {
+				all.Fire(payloads)
+			}
+			return nil
+		})
+	}
+	return all
+}
+
+// AnyOf returns a once signal that fires when the first of ws is satisfied, with its
+// payload
+func AnyOf(s *Simulation, ws ...Waitable) *Signal {
+	first := NewOnceSignal(s)
+	for _, w := range ws {
+		w.Wait(func(t float64, payload interface{}) []Event {
+			first.Fire(payload)
+			return nil
+		})
+	}
+	return first
+}
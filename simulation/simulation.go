@@ -1,6 +1,36 @@
 package sim
 
-import "container/heap"
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// ErrBudgetExhausted is returned by Simulation.Run when the simulation used up its wall
+// clock budget before the events queue was drained
+var ErrBudgetExhausted = errors.New("sim: wall clock budget exhausted")
+
+// ErrPastEvent is returned by Simulation.Run, wrapped in an error naming the offending
+// callback, when an event is scheduled before the current time and the causality policy
+// is RejectPastEvents
+var ErrPastEvent = errors.New("sim: event scheduled in the past")
+
+// CausalityPolicy is what a simulation does when an event is scheduled before the
+// current time, which would make the clock go backwards
+type CausalityPolicy int
+
+const (
+	// RejectPastEvents drops the event and stops the run, which returns ErrPastEvent
+	RejectPastEvents CausalityPolicy = iota
+	// PanicOnPastEvents panics
+	PanicOnPastEvents
+	// ClampPastEvents schedules the event at the current time instead
+	ClampPastEvents
+)
 
 // Run an event based simulation. The core of the simulation is a for loop that pops the
 // next event from an event queue in time order, calls the callback function associated to
@@ -18,27 +48,272 @@ import "container/heap"
 //  - q: the event queue which contains the events to seed the simulation
 //  - timeOverCallback: a callback to perform operations once maxTime is reached. The
 //    callback is useful to stop generating events or in general perform cleanup
+//
+// Run cannot be interrupted; use New and Simulation.Run to bound the simulation with a
+// context or a wall clock budget. Run panics if the simulation fails, for example
+// because a callback scheduled an event in the past.
 func Run(maxTime float64, q *EventsQueue, timeOverCallback OnTimeOver) {
-	t := 0.0
+	if _, err := New(maxTime, q, timeOverCallback).Run(context.Background()); err != nil {
+		panic(err)
+	}
+}
+
+// OnTimeOver is the callback function called by the simulator when the simulation
+// maxTime is reached
+type OnTimeOver func()
+
+// Simulation is an event based simulation which can be stopped before the events queue
+// is drained, either by cancelling a context or by exhausting a wall clock budget.
+// A stopped simulation keeps its state: calling Run again resumes it from the next
+// event.
+type Simulation struct {
+	// MaxTime is how long the simulation runs, in simulation time
+	MaxTime float64
+	// Budget is the maximum wall clock time a call to Run is allowed to take. Zero means
+	// no limit
+	Budget time.Duration
+	// Causality is what to do with the events scheduled before the current time
+	Causality CausalityPolicy
+
+	q          *EventsQueue
+	onTimeOver OnTimeOver
+	now        float64
+	stats      Stats
+	handlers   map[string]Callback
+	states     map[string]State
+	cloners    map[string]Cloner
+	src        *Source
+	rnd        *rand.Rand
+	pacer      *pacer
+	clock      *VirtualClock
+	profiler   *Profiler
+	// dispatching is the event whose callback is running, if any
+	dispatching *Event
+	// violation is the first causality violation of the run
+	violation error
+
+	components     map[string]Component
+	componentPaths []string
+	// clonerNames are the names of the cloners in the order they were registered
+	clonerNames []string
+	tracers     []tracer
+}
+
+// Stats are the statistics of a simulation run. When Run stops early they describe the
+// part of the simulation that completed.
+type Stats struct {
+	// Events is the number of events dispatched
+	Events int
+	// Time is the simulation time of the last dispatched event
+	Time float64
+	// Elapsed is the wall clock time spent dispatching events
+	Elapsed time.Duration
+	// Completed is true when the simulation ran until the events queue was empty
+	Completed bool
+}
+
+// New returns a simulation seeded with the events in q. The parameters have the same
+// meaning as in Run.
+func New(maxTime float64, q *EventsQueue, timeOverCallback OnTimeOver) *Simulation {
+	for _, e := range *q {
+		// the seed events can be cancelled like the scheduled ones
+		e.status = queued
+	}
 	heap.Init(q)
+	src := NewSource(0)
+	return &Simulation{
+		MaxTime:    maxTime,
+		q:          q,
+		onTimeOver: timeOverCallback,
+		handlers:   make(map[string]Callback),
+		states:     make(map[string]State),
+		cloners:    make(map[string]Cloner),
+		src:        src,
+		rnd:        rand.New(src),
+		pacer:      newPacer(0),
+		components: make(map[string]Component),
+	}
+}
+
+// Register associates a name to a callback, so that events can refer to it with
+// Event.Handler. Registering the same name twice replaces the callback.
+func (s *Simulation) Register(name string, cb Callback) {
+	s.handlers[name] = cb
+}
+
+// Schedule adds an event to the simulation and returns a handle to it that can be used
+// to cancel the event. It is meant for the code that runs outside of the callbacks, as
+// callbacks schedule events by returning them. Events scheduled before the current time
+// are handled according to the causality policy of the simulation.
+func (s *Simulation) Schedule(e Event) *Event {
+	ev := &e
+	s.push(ev)
+	return ev
+}
+
+// Cancel prevents a scheduled event from being dispatched. It returns false if the event
+// was already dispatched or cancelled.
+func (s *Simulation) Cancel(e *Event) bool {
+	if e.status != queued {
+		return false
+	}
+	e.status = cancelled
+	return true
+}
+
+func (s *Simulation) push(e *Event) {
+	if e.Time < s.now {
+		by := "code outside of the callbacks"
+		if s.dispatching != nil {
+			by = callbackName(s.dispatching)
+		}
+		msg := fmt.Sprintf("%s scheduled an event at %v, before the current time %v",
+			by, e.Time, s.now)
+		switch s.Causality {
+		case PanicOnPastEvents:
+			panic("sim: " + msg)
+		case ClampPastEvents:
+			e.Time = s.now
+		default:
+			if s.violation == nil {
+				s.violation = fmt.Errorf("%w: %s", ErrPastEvent, msg)
+			}
+			return
+		}
+	}
+	e.status = queued
+	heap.Push(s.q, e)
+}
+
+// Rand returns the random number generator of the simulation. Unlike the global
+// math/rand generator its state is part of the simulation snapshots.
+func (s *Simulation) Rand() *rand.Rand {
+	return s.rnd
+}
+
+// Seed seeds the random number generator returned by Rand
+func (s *Simulation) Seed(seed int64) {
+	s.src.Seed(seed)
+}
+
+// Now returns the current simulation time, that is the time of the event being
+// dispatched or of the last dispatched event
+func (s *Simulation) Now() float64 {
+	return s.now
+}
+
+// Run the simulation until the events queue is empty, ctx is done or the wall clock
+// budget is exhausted. The simulation only stops at event boundaries: the callback of
+// an event always runs to completion and the events it returns are queued.
+//
+// Run returns the stats accumulated so far together with ctx.Err() when the context is
+// done, ErrBudgetExhausted when the budget is exhausted, nil otherwise.
+func (s *Simulation) Run(ctx context.Context) (Stats, error) {
+	return s.run(ctx, math.Inf(1))
+}
+
+// RunUntil is like Run but it also stops, with a nil error, before dispatching the
+// first event whose time is after until. It is useful to bring a simulation to a given
+// point in time, for example to take a snapshot.
+func (s *Simulation) RunUntil(ctx context.Context, until float64) (Stats, error) {
+	return s.run(ctx, until)
+}
+
+func (s *Simulation) run(ctx context.Context, until float64) (Stats, error) {
+	start := time.Now()
+	elapsed := s.stats.Elapsed
+	var deadline time.Time
+	if s.Budget > 0 {
+		deadline = start.Add(s.Budget)
+	}
+	s.pacer.start(s.now)
+	if err := s.violation; err != nil {
+		// an event was scheduled in the past before the run started
+		s.violation = nil
+		return s.stats, err
+	}
+	if s.profiler != nil && s.profiler.Out != nil {
+		defer s.profiler.WriteTo(s.profiler.Out)
+	}
 
-	for q.Len() > 0 {
-		item := heap.Pop(q)
+	done := ctx.Done()
+	for s.q.Len() > 0 {
+		next := (*s.q)[0]
+		if next.status == cancelled {
+			heap.Pop(s.q)
+			continue
+		}
+		if next.Time > until {
+			s.stats.Elapsed = elapsed + time.Since(start)
+			return s.stats, nil
+		}
+		// the wall clock is only read when pacing or with a budget: it is a good part of
+		// the cost of dispatching an event otherwise
+		if s.pacer.active() {
+			if err := s.pacer.wait(ctx, next.Time, deadline); err != nil {
+				s.stats.Elapsed = elapsed + time.Since(start)
+				return s.stats, err
+			}
+		}
+		select {
+		case <-done:
+			s.stats.Elapsed = elapsed + time.Since(start)
+			return s.stats, ctx.Err()
+		default:
+		}
+		if s.Budget > 0 {
+			if spent := time.Since(start); spent >= s.Budget {
+				s.stats.Elapsed = elapsed + spent
+				return s.stats, ErrBudgetExhausted
+			}
+		}
+		cb := next.CallbackFun
+		if cb == nil {
+			var ok bool
+			if cb, ok = s.handlers[next.Handler]; !ok {
+				s.stats.Elapsed = elapsed + time.Since(start)
+				return s.stats, fmt.Errorf("sim: no callback registered for handler %q",
+					next.Handler)
+			}
+		}
+
+		queueLen := s.q.Len()
+		item := heap.Pop(s.q)
 		e := item.(*Event)
-		t = e.Time
+		e.status = dispatched
+		s.now = e.Time
 
-		events := e.CallbackFun(t, e.Payload)
+		var cbStart time.Time
+		if s.profiler != nil {
+			cbStart = time.Now()
+		}
+		s.dispatching = e
+		events := cb(s.now, e.Payload)
 		for _, ev := range events {
 			evCopy := ev
-			heap.Push(q, &evCopy)
+			s.push(&evCopy)
+		}
+		if s.profiler != nil {
+			s.profiler.record(e, time.Since(cbStart), queueLen)
+		}
+		if s.clock != nil {
+			s.clock.settle()
+		}
+		s.dispatching = nil
+		s.stats.Events++
+		s.stats.Time = s.now
+		if err := s.violation; err != nil {
+			s.violation = nil
+			s.stats.Elapsed = elapsed + time.Since(start)
+			return s.stats, err
 		}
 
-		if t > maxTime {
-			timeOverCallback()
+		if s.now > s.MaxTime && s.onTimeOver != nil {
+			s.onTimeOver()
 		}
 	}
-}
 
-// OnTimeOver is the callback function called by the simulator when the simulation
-// maxTime is reached
-type OnTimeOver func()
+	s.stats.Elapsed = elapsed + time.Since(start)
+	s.stats.Completed = true
+	return s.stats, nil
+}
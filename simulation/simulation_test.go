@@ -1,11 +1,18 @@
 package sim
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"math"
 	mathrand "math/rand"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestSimulationCore(t *testing.T) {
@@ -24,6 +31,711 @@ var _ = Describe("Client side load balancing", func() {
 	})
 })
 
+var _ = Describe("Stopping a simulation", func() {
+	It("stops at an event boundary when the context is cancelled and can be resumed", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		ticks := 0
+		var tick Callback
+		tick = func(t float64, payload interface{}) []Event {
+			ticks++
+			if ticks == 10 {
+				cancel()
+			}
+			return []Event{{Time: t + 1, CallbackFun: tick}}
+		}
+		s := New(100, &EventsQueue{{Time: 0, CallbackFun: tick}}, nil)
+
+		stats, err := s.Run(ctx)
+		Expect(err).To(MatchError(context.Canceled))
+		Expect(stats.Events).To(Equal(10))
+		Expect(stats.Time).To(Equal(9.0))
+		Expect(stats.Completed).To(BeFalse())
+
+		// tick never stops, the budget does
+		s.Budget = 10 * time.Millisecond
+		stats, err = s.Run(context.Background())
+		Expect(err).To(MatchError(ErrBudgetExhausted))
+		Expect(stats.Events).To(BeNumerically(">", 10))
+		Expect(stats.Time).To(Equal(float64(stats.Events - 1)))
+	})
+
+	It("accounts for the time spent before an event without callback", func() {
+		slow := func(t float64, payload interface{}) []Event {
+			time.Sleep(2 * time.Millisecond)
+			return []Event{{Time: t + 1, Handler: "missing"}}
+		}
+		s := New(100, &EventsQueue{{Time: 0, CallbackFun: slow}}, nil)
+
+		stats, err := s.Run(context.Background())
+		Expect(err).To(MatchError(ContainSubstring(`no callback registered for handler "missing"`)))
+		Expect(stats.Events).To(Equal(1))
+		Expect(stats.Elapsed).To(BeNumerically(">=", 2*time.Millisecond))
+	})
+})
+
+var _ = Describe("Cancelling events", func() {
+	It("cancels the seed events like the scheduled ones", func() {
+		var fired []float64
+		record := func(t float64, payload interface{}) []Event {
+			fired = append(fired, t)
+			return nil
+		}
+		seed := &Event{Time: 1, CallbackFun: record}
+		s := New(100, &EventsQueue{seed, {Time: 2, CallbackFun: record}}, nil)
+		scheduled := s.Schedule(Event{Time: 3, CallbackFun: record})
+		Expect(s.Cancel(seed)).To(BeTrue())
+		Expect(s.Cancel(seed)).To(BeFalse())
+		Expect(s.Cancel(scheduled)).To(BeTrue())
+
+		_, err := s.Run(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fired).To(Equal([]float64{2}))
+	})
+})
+
+var _ = Describe("Activities", func() {
+	It("completes or reports the interruption with the remaining time", func() {
+		s := New(100, &EventsQueue{}, nil)
+		var completed []interface{}
+		var interrupted []*Interruption
+		complete := func(t float64, payload interface{}) []Event {
+			completed = append(completed, payload)
+			return nil
+		}
+		interrupt := func(t float64, payload interface{}) []Event {
+			interrupted = append(interrupted, payload.(*Interruption))
+			return nil
+		}
+		var long, short *Activity
+		s.Schedule(Event{Time: 1, CallbackFun: func(t float64, payload interface{}) []Event {
+			long = s.StartActivity(10, complete, "long", interrupt)
+			short = s.StartActivity(2, complete, "short", interrupt)
+			return nil
+		}})
+		crash := errors.New("crash")
+		s.Schedule(Event{Time: 4, CallbackFun: func(t float64, payload interface{}) []Event {
+			Expect(long.Active()).To(BeTrue())
+			Expect(short.Active()).To(BeFalse())
+			Expect(long.Interrupt(crash)).To(BeTrue())
+			Expect(long.Active()).To(BeFalse())
+			// neither a completed nor an interrupted activity can be interrupted
+			Expect(long.Interrupt(crash)).To(BeFalse())
+			Expect(short.Interrupt(crash)).To(BeFalse())
+			return nil
+		}})
+
+		_, err := s.Run(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(completed).To(Equal([]interface{}{"short"}))
+		Expect(interrupted).To(HaveLen(1))
+		Expect(*interrupted[0]).To(Equal(Interruption{
+			Reason: crash, Payload: "long", Started: 1, Remaining: 7}))
+	})
+})
+
+var _ = Describe("Snapshots", func() {
+	It("resumes a restored simulation exactly where the original one was", func() {
+		original, w := newWalk()
+		_, err := original.RunUntil(context.Background(), 50)
+		Expect(err).NotTo(HaveOccurred())
+		var buf bytes.Buffer
+		Expect(original.Snapshot(&buf)).To(Succeed())
+		_, err = original.Run(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+
+		restored, restoredW := newWalk()
+		Expect(restored.Restore(&buf)).To(Succeed())
+		Expect(restored.Now()).To(BeNumerically("<=", 50))
+		_, err = restored.Run(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(restoredW.Position).To(Equal(w.Position))
+		Expect(restored.Now()).To(Equal(original.Now()))
+	})
+})
+
+var _ = Describe("Forks", func() {
+	It("runs independent futures from the same state", func() {
+		original, w := newWalk()
+		_, err := original.RunUntil(context.Background(), 50)
+		Expect(err).NotTo(HaveOccurred())
+
+		same, err := original.Fork()
+		Expect(err).NotTo(HaveOccurred())
+		whatIf, err := original.Fork()
+		Expect(err).NotTo(HaveOccurred())
+		whatIf.Cloned("walker").(*walker).Down = 1
+
+		for _, s := range []*Simulation{original, same, whatIf} {
+			_, err = s.Run(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		Expect(same.Cloned("walker").(*walker).Position).To(Equal(w.Position))
+		Expect(same.Now()).To(Equal(original.Now()))
+		Expect(whatIf.Cloned("walker").(*walker).Position).To(BeNumerically("<", w.Position))
+	})
+
+	It("keeps the causality policy of the original", func() {
+		original, _ := newWalk()
+		original.Causality = ClampPastEvents
+		f, err := original.Fork()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(f.Causality).To(Equal(ClampPastEvents))
+		f.Schedule(Event{Time: -1, Handler: "step"})
+		_, err = f.Run(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("copies the handlers that do not belong to a component", func() {
+		original, _ := newWalk()
+		var ticks []float64
+		original.Register("tick", func(t float64, payload interface{}) []Event {
+			ticks = append(ticks, t)
+			return nil
+		})
+		original.Schedule(Event{Time: 200, Handler: "tick"})
+		f, err := original.Fork()
+		Expect(err).NotTo(HaveOccurred())
+		_, err = f.Run(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ticks).To(Equal([]float64{200}))
+	})
+
+	It("clones the components in the order they were registered", func() {
+		original, w := newWalk()
+		for i := 0; i < 10; i++ {
+			original.RegisterCloner(fmt.Sprintf("follower-%d", i), &follower{w: w})
+		}
+		f, err := original.Fork()
+		Expect(err).NotTo(HaveOccurred())
+		for i := 0; i < 10; i++ {
+			Expect(f.Cloned(fmt.Sprintf("follower-%d", i)).(*follower).w).To(
+				BeIdenticalTo(f.Cloned("walker")))
+		}
+	})
+})
+
+var _ = Describe("Paced simulations", func() {
+	It("dispatches events against the wall clock and can be paused", func() {
+		var dispatched []float64
+		var tick Callback
+		var s *Simulation
+		tick = func(t float64, payload interface{}) []Event {
+			dispatched = append(dispatched, t)
+			if t == 5 {
+				s.Pause()
+				time.AfterFunc(50*time.Millisecond, s.Resume)
+			}
+			if t >= 10 {
+				return nil
+			}
+			return []Event{{Time: t + 1, CallbackFun: tick}}
+		}
+		s = New(100, &EventsQueue{{Time: 0, CallbackFun: tick}}, nil)
+		// 10 units of simulation time take 100ms
+		s.Pace(100)
+
+		stats, err := s.Run(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(dispatched).To(Equal([]float64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10}))
+		Expect(stats.Elapsed).To(BeNumerically(">=", 150*time.Millisecond))
+	})
+})
+
+var _ = Describe("Virtual clock", func() {
+	It("runs code written against Clock in simulation time", func() {
+		s := New(100, &EventsQueue{}, nil)
+		clock := NewVirtualClock(s, time.Unix(0, 0))
+		var attempts []string
+		// op sends a request that takes 0.5s to complete and always fails
+		op := func(name string) func() error {
+			return func() error {
+				attempts = append(attempts, fmt.Sprintf("%s@%v", name, clock.Now().Unix()))
+				clock.Await(func(resume func()) {
+					s.Schedule(Event{
+						Time: s.Now() + 0.5,
+						CallbackFun: func(t float64, payload interface{}) []Event {
+							resume()
+							return nil
+						},
+					})
+				})
+				return errors.New("failed")
+			}
+		}
+
+		clock.Go(func() {
+			_ = retry(clock, 3, 500*time.Millisecond, op("a"))
+		})
+		clock.AfterFunc(2*time.Second, func() {
+			_ = retry(clock, 2, 1500*time.Millisecond, op("b"))
+		})
+		_, err := s.Run(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(attempts).To(Equal([]string{"a@0", "a@1", "b@2", "a@2", "b@4"}))
+		Expect(s.Now()).To(Equal(4.5))
+	})
+
+	It("lets a process use the clock between arming a timer and waiting for it", func() {
+		s := New(100, &EventsQueue{}, nil)
+		clock := NewVirtualClock(s, time.Unix(0, 0))
+		var woken []int64
+		clock.Go(func() {
+			deadline := clock.NewTimer(10 * time.Second)
+			for i := 0; i < 3; i++ {
+				clock.Sleep(time.Second)
+				woken = append(woken, clock.Now().Unix())
+			}
+			<-deadline.C()
+			woken = append(woken, clock.Now().Unix())
+			// a stopped timer does not fire
+			stopped := clock.NewTimer(time.Second)
+			clock.Sleep(500 * time.Millisecond)
+			stopped.Stop()
+			clock.Sleep(time.Second)
+			woken = append(woken, clock.Now().Unix())
+		})
+		_, err := s.Run(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(woken).To(Equal([]int64{1, 2, 3, 10, 11}))
+	})
+
+	It("treats the durations that already expired as zero", func() {
+		s := New(100, &EventsQueue{}, nil)
+		clock := NewVirtualClock(s, time.Unix(0, 0))
+		var woken []float64
+		clock.Go(func() {
+			clock.Sleep(2 * time.Second)
+			deadline := clock.Now().Add(-time.Second)
+			clock.Sleep(deadline.Sub(clock.Now()))
+			woken = append(woken, s.Now())
+			<-clock.After(deadline.Sub(clock.Now()))
+			woken = append(woken, s.Now())
+		})
+		fired := false
+		clock.AfterFunc(-time.Second, func() { fired = true })
+		_, err := s.Run(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(woken).To(Equal([]float64{2, 2}))
+		Expect(fired).To(BeTrue())
+	})
+})
+
+var _ = Describe("Profiler", func() {
+	It("reports events and time by callback", func() {
+		stats := newStats()
+		server := &server{stats: stats}
+		q := &EventsQueue{{Time: 0, CallbackFun: server.call, Payload: request{"server-1"}}}
+		for i := 1; i < 10; i++ {
+			*q = append(*q, &Event{Time: float64(i), Handler: "noop"})
+		}
+		s := New(100, q, nil)
+		s.Register("noop", func(t float64, payload interface{}) []Event { return nil })
+		var out bytes.Buffer
+		s.Profile(NewProfiler(&out))
+
+		_, err := s.Run(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(out.String()).To(ContainSubstring("events: 10, queue high-water mark: 10"))
+		Expect(out.String()).To(MatchRegexp(`simulation\.\(\*server\)\.call +1 `))
+		Expect(out.String()).To(MatchRegexp(`noop +9 `))
+	})
+})
+
+var _ = Describe("Signals, conditions and barriers", func() {
+	It("schedules the waiting callbacks once they are satisfied", func() {
+		s := New(100, &EventsQueue{}, nil)
+		var woken []string
+		wake := func(name string) Callback {
+			return func(t float64, payload interface{}) []Event {
+				woken = append(woken, fmt.Sprintf("%s@%v:%v", name, t, payload))
+				return nil
+			}
+		}
+
+		// a queue that drains one item per time unit
+		queue := 3
+		drained := NewCondition(s, func() bool { return queue == 0 })
+		drained.Wait(wake("drained"))
+		var drain Callback
+		drain = func(t float64, payload interface{}) []Event {
+			queue--
+			drained.Notify()
+			if queue == 0 {
+				return nil
+			}
+			return []Event{{Time: t + 1, CallbackFun: drain}}
+		}
+		s.Schedule(Event{Time: 1, CallbackFun: drain})
+
+		// a fan-out whose replies arrive at time 2 and 5
+		replies := NewBarrier(s, 2)
+		for _, at := range []float64{2, 5} {
+			at := at
+			s.Schedule(Event{Time: at, CallbackFun: func(t float64, payload interface{}) []Event {
+				replies.Arrive(at)
+				return nil
+			}})
+		}
+		replies.Wait(wake("replies"))
+
+		tick := NewSignal(s)
+		AnyOf(s, tick, replies).Wait(wake("any"))
+		AllOf(s, drained, replies).Wait(wake("all"))
+		s.Schedule(Event{Time: 4, CallbackFun: func(t float64, payload interface{}) []Event {
+			tick.Fire("tick")
+			return nil
+		}})
+
+		_, err := s.Run(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		// events at the same time are not dispatched in a specific order
+		Expect(woken).To(ConsistOf(
+			"drained@3:<nil>", "any@4:tick", "replies@5:[2 5]", "all@5:[<nil> [2 5]]"))
+		Expect(woken[:2]).To(Equal([]string{"drained@3:<nil>", "any@4:tick"}))
+	})
+})
+
+var _ = Describe("Components", func() {
+	It("are registered by path, traced and report their stats", func() {
+		s := New(100, &EventsQueue{}, nil)
+		cluster := &counter{Base: NewBase("cluster", nil)}
+		servers := []*counter{
+			{Base: NewBase("server-1", cluster), ticks: 2},
+			{Base: NewBase("server-2", cluster), ticks: 3},
+		}
+		var all, server2 bytes.Buffer
+		s.Trace("", &all)
+		s.Trace("cluster/server-2", &server2)
+		for _, c := range []*counter{cluster, servers[0], servers[1]} {
+			Expect(s.Add(c)).To(Succeed())
+		}
+		Expect(s.Add(&counter{Base: NewBase("server-1", cluster)})).NotTo(Succeed())
+
+		_, err := s.Run(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.Component("cluster/server-2")).To(BeIdenticalTo(servers[1]))
+		Expect(s.Components()).To(HaveLen(3))
+		Expect(s.ComponentStats()).To(Equal(map[string]map[string]float64{
+			"cluster":          {"ticks": 0},
+			"cluster/server-1": {"ticks": 2},
+			"cluster/server-2": {"ticks": 3},
+		}))
+		Expect(server2.String()).To(Equal("t=1.000 cluster/server-2: tick\n" +
+			"t=2.000 cluster/server-2: tick\n" +
+			"t=3.000 cluster/server-2: tick\n"))
+		Expect(strings.Count(all.String(), "tick")).To(Equal(5))
+	})
+})
+
+// counter ticks once per time unit until it counted the given number of ticks
+type counter struct {
+	Base
+	ticks   int
+	counted int
+}
+
+func (c *counter) Start(s *Simulation) []Event {
+	var tick Callback
+	tick = func(t float64, payload interface{}) []Event {
+		if c.counted == c.ticks {
+			return nil
+		}
+		c.counted++
+		s.Tracef(c, "tick")
+		return []Event{{Time: t + 1, CallbackFun: tick}}
+	}
+	return []Event{{Time: 1, CallbackFun: tick}}
+}
+
+func (c *counter) Stats() map[string]float64 {
+	return map[string]float64{"ticks": float64(c.counted)}
+}
+
+var _ = Describe("Parallel simulations", func() {
+	It("give the same results regardless of the number of workers", func() {
+		sequential := runTopology(newParallelTopology(1))
+		parallel := runTopology(newParallelTopology(8))
+
+		Expect(sequential.Completed).To(BeTrue())
+		Expect(sequential.Events).To(BeNumerically(">", 5000))
+		Expect(parallel).To(Equal(sequential))
+	})
+
+	It("give the same results as a sequential simulation with a generator per component", func() {
+		Expect(runTopology(newParallelTopology(8))).To(Equal(runTopology(newSequentialTopology())))
+	})
+
+	It("fails when an event is sent before the lookahead", func() {
+		p := NewParallel(10, 1, nil)
+		a, b := p.NewProcess(1), p.NewProcess(2)
+		a.Schedule(Event{Time: 0, CallbackFun: func(t float64, payload interface{}) []Event {
+			a.Send(b, Event{Time: t + 0.5, CallbackFun: func(float64, interface{}) []Event {
+				return nil
+			}})
+			return nil
+		}})
+
+		_, err := p.Run(context.Background())
+		Expect(err).To(MatchError(ContainSubstring("before the lookahead")))
+	})
+})
+
+// topologyResult is the outcome of runTopology
+type topologyResult struct {
+	Stats
+	served       []int
+	latencySum   []float64
+	completedReq []int
+}
+
+// topology is the engine running the model of runTopology, whose components have each
+// their own random number generator: a logical process of a Parallel simulation, or a
+// generator of a sequential Simulation
+type topology interface {
+	newProcess(seed int64) topologyProcess
+	run() (Stats, error)
+}
+
+type topologyProcess interface {
+	Rand() *mathrand.Rand
+	Schedule(e Event)
+	// send schedules e on the process to
+	send(to topologyProcess, e Event)
+}
+
+type parallelTopology struct {
+	p *Parallel
+}
+
+type logicalProcess struct {
+	*LogicalProcess
+}
+
+func newParallelTopology(workers int) topology {
+	p := NewParallel(200, topologyNetwork, nil)
+	p.Workers = workers
+	return parallelTopology{p}
+}
+
+func (t parallelTopology) newProcess(seed int64) topologyProcess {
+	return logicalProcess{t.p.NewProcess(seed)}
+}
+
+func (t parallelTopology) run() (Stats, error) {
+	return t.p.Run(context.Background())
+}
+
+func (t logicalProcess) send(to topologyProcess, e Event) {
+	t.Send(to.(logicalProcess).LogicalProcess, e)
+}
+
+type sequentialTopology struct {
+	s *Simulation
+}
+
+type sequentialProcess struct {
+	s   *Simulation
+	rnd *mathrand.Rand
+}
+
+func newSequentialTopology() topology {
+	return sequentialTopology{New(200, &EventsQueue{}, nil)}
+}
+
+func (t sequentialTopology) newProcess(seed int64) topologyProcess {
+	return sequentialProcess{s: t.s, rnd: mathrand.New(NewSource(seed))}
+}
+
+func (t sequentialTopology) run() (Stats, error) {
+	return t.s.Run(context.Background())
+}
+
+func (t sequentialProcess) Rand() *mathrand.Rand { return t.rnd }
+func (t sequentialProcess) Schedule(e Event)     { t.s.Schedule(e) }
+
+func (t sequentialProcess) send(to topologyProcess, e Event) {
+	t.s.Schedule(e)
+}
+
+// topologyNetwork is the minimum latency of the network of runTopology
+const topologyNetwork = 0.1
+
+// runTopology simulates on t 10 clients, each in its own process, calling 20 servers,
+// each in its own process, over a network with at least topologyNetwork of latency
+func runTopology(t topology) topologyResult {
+	const network = topologyNetwork
+
+	res := topologyResult{served: make([]int, 20)}
+	servers := make([]topologyProcess, 20)
+	busyUntil := make([]float64, 20)
+	for i := range servers {
+		servers[i] = t.newProcess(int64(100 + i))
+	}
+
+	for i := 0; i < 10; i++ {
+		i := i
+		client := t.newProcess(int64(i))
+		res.latencySum = append(res.latencySum, 0)
+		res.completedReq = append(res.completedReq, 0)
+		var call Callback
+		call = func(t float64, payload interface{}) []Event {
+			if t > 200 {
+				return nil
+			}
+			srv := client.Rand().Intn(len(servers))
+			server := servers[srv]
+			sent := t
+			client.send(server, Event{
+				Time: t + network + client.Rand().ExpFloat64()*network,
+				CallbackFun: func(t float64, payload interface{}) []Event {
+					// FIFO server: the request completes after the ones before it
+					start := math.Max(t, busyUntil[srv])
+					busyUntil[srv] = start + server.Rand().ExpFloat64()*0.2
+					res.served[srv]++
+					server.send(client, Event{
+						Time: busyUntil[srv] + network,
+						CallbackFun: func(t float64, payload interface{}) []Event {
+							res.latencySum[i] += t - sent
+							res.completedReq[i]++
+							return nil
+						},
+					})
+					return nil
+				},
+			})
+			return []Event{{Time: t + client.Rand().ExpFloat64(), CallbackFun: call}}
+		}
+		client.Schedule(Event{Time: 0, CallbackFun: call})
+	}
+
+	stats, err := t.run()
+	Expect(err).NotTo(HaveOccurred())
+	stats.Elapsed = 0
+	res.Stats = stats
+	return res
+}
+
+var _ = Describe("Events scheduled in the past", func() {
+	newSimulation := func(policy CausalityPolicy) (*Simulation, *[]float64) {
+		var dispatched []float64
+		q := &EventsQueue{{Time: 5, CallbackFun: func(t float64, payload interface{}) []Event {
+			dispatched = append(dispatched, t)
+			return []Event{{Time: t - 1, CallbackFun: func(t float64, payload interface{}) []Event {
+				dispatched = append(dispatched, t)
+				return nil
+			}}}
+		}}}
+		s := New(100, q, nil)
+		s.Causality = policy
+		return s, &dispatched
+	}
+
+	It("are rejected by default", func() {
+		s, dispatched := newSimulation(RejectPastEvents)
+		_, err := s.Run(context.Background())
+		Expect(err).To(MatchError(ErrPastEvent))
+		Expect(err.Error()).To(ContainSubstring("scheduled an event at 4, before the current time 5"))
+		Expect(*dispatched).To(Equal([]float64{5}))
+	})
+
+	It("are clamped to the current time", func() {
+		s, dispatched := newSimulation(ClampPastEvents)
+		_, err := s.Run(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*dispatched).To(Equal([]float64{5, 5}))
+	})
+
+	It("panic", func() {
+		s, _ := newSimulation(PanicOnPastEvents)
+		Expect(func() { _, _ = s.Run(context.Background()) }).To(Panic())
+	})
+})
+
+// retry calls op until it succeeds or it was called the given number of attempts,
+// waiting an exponentially increasing backoff between calls. It is written against Clock
+// like production code would be.
+func retry(clock Clock, attempts int, backoff time.Duration, op func() error) error {
+	ctx := context.Background()
+	for i := 1; ; i++ {
+		err := op()
+		if err == nil || i == attempts {
+			return err
+		}
+		if i%2 == 0 {
+			clock.Sleep(backoff)
+		} else {
+			select {
+			case <-clock.After(backoff):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		backoff *= 2
+	}
+}
+
+// newWalk returns a simulation of a random walk seeded with a constant value
+func newWalk() (*Simulation, *walker) {
+	w := &walker{Down: 0.5}
+	s := New(100, &EventsQueue{{Time: 0, Handler: "step"}}, nil)
+	s.Seed(42)
+	w.register(s)
+	return s, w
+}
+
+// walker moves one step at the time until time 100, down with probability Down and up
+// otherwise
+type walker struct {
+	Position int
+	Down     float64
+}
+
+func (w *walker) register(s *Simulation) {
+	s.Register("step", func(t float64, payload interface{}) []Event {
+		return w.step(s, t)
+	})
+	s.RegisterState("walker", w)
+	s.RegisterCloner("walker", w)
+}
+
+func (w *walker) step(s *Simulation, t float64) []Event {
+	if s.Rand().Float64() < w.Down {
+		w.Position--
+	} else {
+		w.Position++
+	}
+	if t > 100 {
+		return nil
+	}
+	return []Event{{Time: t + s.Rand().ExpFloat64(), Handler: "step"}}
+}
+
+func (w *walker) MarshalBinary() ([]byte, error) {
+	return json.Marshal(w)
+}
+
+func (w *walker) UnmarshalBinary(b []byte) error {
+	return json.Unmarshal(b, w)
+}
+
+func (w *walker) Clone(f *Simulation) Cloner {
+	clone := *w
+	clone.register(f)
+	return &clone
+}
+
+// follower refers to a walker, and its copies to the copy of the walker in their fork
+type follower struct {
+	w *walker
+}
+
+func (t *follower) Clone(f *Simulation) Cloner {
+	return &follower{w: f.Cloned("walker").(*walker)}
+}
+
 // A very simple simulation to show how to use the simulator.
 //
 // It runs an sample simulation with one client and one server. The client calls the server
@@ -0,0 +1,117 @@
+package sim
+
+import (
+	"encoding"
+	"encoding/gob"
+	"fmt"
+	"io"
+)
+
+// State is implemented by the components of a simulation whose state is saved in the
+// snapshots. Components are registered with Simulation.RegisterState.
+type State interface {
+	encoding.BinaryMarshaler
+	encoding.BinaryUnmarshaler
+}
+
+// RegisterState adds a component to the ones saved by Snapshot and loaded by Restore.
+// The name identifies the component in the snapshot.
+func (s *Simulation) RegisterState(name string, st State) {
+	s.states[name] = st
+}
+
+// snapshot is the serializable state of a Simulation
+type snapshot struct {
+	Now    float64
+	Stats  Stats
+	Rand   uint64
+	Events []snapshotEvent
+	States map[string][]byte
+}
+
+type snapshotEvent struct {
+	Time      float64
+	Handler   string
+	Payload   interface{}
+	Cancelled bool
+}
+
+// Snapshot writes the state of the simulation to w: clock, pending events, state of the
+// random number generator returned by Rand and state of the registered components.
+// Snapshot must be called between runs, i.e. not from a callback.
+//
+// Every pending event must refer to its callback by Handler, and the concrete types of
+// the payloads must be registered with gob.Register.
+func (s *Simulation) Snapshot(w io.Writer) error {
+	snap := snapshot{
+		Now:    s.now,
+		Stats:  s.stats,
+		Rand:   s.src.state,
+		States: make(map[string][]byte, len(s.states)),
+	}
+	// events are saved in heap order, so that a restored simulation dispatches events
+	// with the same time in the same order as the original one
+	for _, e := range *s.q {
+		if e.status == cancelled {
+			// cancelled events keep their place in the heap but nothing else is saved
+			snap.Events = append(snap.Events, snapshotEvent{Time: e.Time, Cancelled: true})
+			continue
+		}
+		if e.CallbackFun != nil || e.Handler == "" {
+			return fmt.Errorf("sim: event at time %v has no handler name", e.Time)
+		}
+		snap.Events = append(snap.Events, snapshotEvent{
+			Time:    e.Time,
+			Handler: e.Handler,
+			Payload: e.Payload,
+		})
+	}
+	for name, st := range s.states {
+		b, err := st.MarshalBinary()
+		if err != nil {
+			return fmt.Errorf("sim: saving state of %q: %w", name, err)
+		}
+		snap.States[name] = b
+	}
+
+	return gob.NewEncoder(w).Encode(&snap)
+}
+
+// Restore loads in the simulation a snapshot written by Snapshot, replacing its clock,
+// pending events and random number generator state. The simulation must have the same
+// handlers and components registered as the one the snapshot was taken from; loading a
+// snapshot in several simulations forks the original one into independent branches.
+func (s *Simulation) Restore(r io.Reader) error {
+	var snap snapshot
+	if err := gob.NewDecoder(r).Decode(&snap); err != nil {
+		return fmt.Errorf("sim: decoding snapshot: %w", err)
+	}
+
+	q := make(EventsQueue, 0, len(snap.Events))
+	for _, e := range snap.Events {
+		if e.Cancelled {
+			q = append(q, &Event{Time: e.Time, status: cancelled})
+			continue
+		}
+		if _, ok := s.handlers[e.Handler]; !ok {
+			return fmt.Errorf("sim: no callback registered for handler %q", e.Handler)
+		}
+		q = append(q, &Event{
+			Time: e.Time, Handler: e.Handler, Payload: e.Payload, status: queued})
+	}
+	for name, st := range s.states {
+		b, ok := snap.States[name]
+		if !ok {
+			return fmt.Errorf("sim: snapshot has no state for %q", name)
+		}
+		if err := st.UnmarshalBinary(b); err != nil {
+			return fmt.Errorf("sim: loading state of %q: %w", name, err)
+		}
+	}
+
+	*s.q = q
+	s.now = snap.Now
+	s.stats = snap.Stats
+	s.src.state = snap.Rand
+	return nil
+}